@@ -0,0 +1,181 @@
+package img2term
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A fully composited frame of an animation
+type Frame struct {
+	Image image.Image
+	Delay time.Duration
+}
+
+// Frames with a shorter delay than this are shown for defaultDelay instead,
+// like browsers do
+const (
+	minDelay     = 2
+	defaultDelay = 100 * time.Millisecond
+)
+
+// Composites the frames of an animated GIF onto its logical screen following
+// their offsets and disposal methods, so every frame has the size of the
+// screen. Disposing to the background clears to transparent
+func Frames(g *gif.GIF) []Frame {
+	bounds := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	if bounds.Empty() {
+		for _, img := range g.Image {
+			bounds = bounds.Union(img.Bounds())
+		}
+	}
+	canvas := image.NewRGBA(bounds)
+	frames := make([]Frame, len(g.Image))
+	for i, img := range g.Image {
+		var disposal byte
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+		var previous *image.RGBA
+		if disposal == gif.DisposalPrevious {
+			previous = cloneRGBA(canvas)
+		}
+		draw.Draw(canvas, img.Bounds(), img, img.Bounds().Min, draw.Over)
+
+		frames[i] = Frame{Image: cloneRGBA(canvas), Delay: defaultDelay}
+		if i < len(g.Delay) && g.Delay[i] >= minDelay {
+			frames[i].Delay = time.Duration(g.Delay[i]) * 10 * time.Millisecond
+		}
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, img.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			draw.Draw(canvas, img.Bounds(), previous, img.Bounds().Min, draw.Src)
+		}
+	}
+	return frames
+}
+
+func cloneRGBA(img *image.RGBA) *image.RGBA {
+	clone := *img
+	clone.Pix = append([]uint8(nil), img.Pix...)
+	return &clone
+}
+
+// Returned by Play for output that frames can't be drawn over each other in
+var ErrNoPlayback = errors.New("animations can only be played with ANSI sequences")
+
+// Terminal sequences used to draw frames over each other
+const (
+	hideCursor  = "\x1B[?25l"
+	showCursor  = "\x1B[?25h"
+	clearLine   = "\x1B[K"
+	clearScreen = "\x1B[J" // From the cursor to the end of the screen
+)
+
+func cursorUp(lines int) string {
+	if lines == 0 {
+		return ""
+	}
+	return "\x1B[" + strconv.Itoa(lines) + "A"
+}
+
+// Plays an animated GIF on the terminal w, drawing every frame in place of the
+// previous one. Frames are rendered the first time they are shown. Playback
+// ends after the number of loops the GIF asks for, or when ctx is done, and
+// the cursor and colors are restored either way. Animations with more than one
+// frame can't be played in the graphics modes, nor written as anything but
+// ANSI sequences (or braille dots in the default format)
+func Play(ctx context.Context, w io.Writer, g *gif.GIF, opts Options) error {
+	if err := opts.validate(); err != nil {
+		return err
+	}
+	frames := Frames(g)
+	if len(frames) == 0 {
+		return nil
+	}
+	if opts.Autocrop {
+		// Crop every frame the same way so that the size doesn't change
+		var rect image.Rectangle
+		for _, frame := range frames {
+			rect = rect.Union(borderBounds(frame.Image))
+		}
+		if !rect.Empty() {
+			for i := range frames {
+				frames[i].Image = cropTo(frames[i].Image, rect)
+			}
+		}
+		opts.Autocrop = false
+	}
+	if len(frames) == 1 {
+		return RenderTo(w, frames[0].Image, opts)
+	}
+	if _, ok := graphicsModes[opts.Mode]; ok {
+		// Frames are drawn over the previous ones by counting their lines
+		return fmt.Errorf("%w: can't play animations in mode %d", ErrNoCells, opts.Mode)
+	}
+	if format := opts.Format; (format != "" && format != "ansi") || defaultFormat(opts.Mode) == "irc" {
+		// The cursor is moved with terminal sequences, which IRC and plain
+		// text don't have
+		return fmt.Errorf("%w: mode %d with format %q", ErrNoPlayback, opts.Mode, format)
+	}
+
+	if _, err := io.WriteString(w, hideCursor); err != nil {
+		return err
+	}
+	defer io.WriteString(w, opts.reset()+showCursor)
+
+	loops := 0 // forever
+	if g.LoopCount < 0 {
+		loops = 1
+	} else if g.LoopCount > 0 {
+		loops = g.LoopCount + 1
+	}
+	rendered := make([]string, len(frames))
+	lines := 0
+	next := time.Now()
+	for played := 0; loops == 0 || played < loops; played++ {
+		for i, frame := range frames {
+			if rendered[i] == "" {
+				text, err := RenderToText(frame.Image, opts)
+				if err != nil {
+					return err
+				}
+				// Clear what's left of longer lines of the previous frame
+				rendered[i] = strings.Replace(text, "\n", clearLine+"\n", -1)
+			}
+			if err := sleepUntil(ctx, next); err != nil {
+				return err
+			}
+			if _, err := io.WriteString(w, cursorUp(lines)+rendered[i]+clearScreen); err != nil {
+				return err
+			}
+			lines = strings.Count(rendered[i], "\n")
+			next = next.Add(frame.Delay)
+			if now := time.Now(); next.Before(now) {
+				// Drop the time lost when rendering falls behind
+				next = now
+			}
+		}
+	}
+	return sleepUntil(ctx, next)
+}
+
+func sleepUntil(ctx context.Context, t time.Time) error {
+	timer := time.NewTimer(time.Until(t))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,49 @@
+package img2term
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+)
+
+func TestPlayRejectsOutput(t *testing.T) {
+	palette := color.Palette{color.Black, color.White}
+	g := &gif.GIF{
+		Image: []*image.Paletted{
+			image.NewPaletted(image.Rect(0, 0, 4, 4), palette),
+			image.NewPaletted(image.Rect(0, 0, 4, 4), palette),
+		},
+		Delay:     []int{1, 1},
+		LoopCount: -1,
+	}
+	for _, mode := range []RenderMode{Sixel, Kitty, ITerm} {
+		var out bytes.Buffer
+		err := Play(context.Background(), &out, g, Options{Mode: mode})
+		if !errors.Is(err, ErrNoCells) {
+			t.Errorf("mode %d: got %v, want ErrNoCells", mode, err)
+		}
+		if out.Len() != 0 {
+			t.Errorf("mode %d: wrote %q", mode, out.String())
+		}
+	}
+	for _, opts := range []Options{{Mode: IRC}, {Mode: IRC16}, {Mode: Term256, Format: "plain"}, {Mode: Braille, Format: "plain"}} {
+		var out bytes.Buffer
+		err := Play(context.Background(), &out, g, opts)
+		if !errors.Is(err, ErrNoPlayback) {
+			t.Errorf("mode %d, format %q: got %v, want ErrNoPlayback", opts.Mode, opts.Format, err)
+		}
+		if out.Len() != 0 {
+			t.Errorf("mode %d, format %q: wrote %q", opts.Mode, opts.Format, out.String())
+		}
+	}
+	for _, opts := range []Options{{Mode: Term24bit}, {Mode: Term16, Format: "ansi"}, {Mode: Braille}} {
+		var out bytes.Buffer
+		if err := Play(context.Background(), &out, g, opts); err != nil {
+			t.Errorf("mode %d, format %q: %v", opts.Mode, opts.Format, err)
+		}
+	}
+}
@@ -0,0 +1,464 @@
+package img2term
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sync"
+
+	"github.com/lucasb-eyer/go-colorful"
+)
+
+// How braille dots are lit from the gray level of their pixels
+type Dots int
+
+const (
+	DotsDiffuse   Dots = iota // Floyd-Steinberg dithering around the threshold
+	DotsOrdered               // Ordered dithering with an 8x8 Bayer matrix
+	DotsThreshold             // Lit above the threshold, without dithering
+	DotsOtsu                  // Lit above the level that best splits the gray levels of the image (Otsu's method)
+	DotsAdaptive              // Lit when brighter than the mean of the pixels around, offset by the threshold
+)
+
+var dotsNames = []string{
+	DotsDiffuse:   "floyd-steinberg",
+	DotsOrdered:   "ordered",
+	DotsThreshold: "none",
+	DotsOtsu:      "otsu",
+	DotsAdaptive:  "adaptive",
+}
+
+func (d Dots) String() string {
+	if d < 0 || int(d) >= len(dotsNames) {
+		return fmt.Sprintf("Dots(%d)", int(d))
+	}
+	return dotsNames[d]
+}
+
+// Returns the names accepted by ParseDots
+func DotsNames() []string {
+	return append([]string(nil), dotsNames...)
+}
+
+func ParseDots(name string) (Dots, error) {
+	for d, n := range dotsNames {
+		if n == name {
+			return Dots(d), nil
+		}
+	}
+	return DotsDiffuse, fmt.Errorf("unknown braille dots method %q", name)
+}
+
+// Pixels on each side of the one being lit that DotsAdaptive averages
+const adaptiveRadius = 8
+
+// Lights braille dots a band of rows at a time, following opts.Dots
+type dotter struct {
+	method Dots
+	level  float64 // Threshold
+	gamma  float64
+	gray   func(colorful.Color) float64
+
+	diffuser   *diffuser
+	thresholds [][]float64
+
+	// The adaptive method needs the rows around the band too
+	rows   pixelRows
+	window [][]Pixel
+}
+
+// Returns the dotter for the image in rows. gray gives the gray level of a
+// color, before the gamma. DotsOtsu reads the whole image once here
+func newDotter(rows pixelRows, opts Options, gray func(colorful.Color) float64) *dotter {
+	d := &dotter{method: opts.Dots, level: 0.5, gamma: opts.DotGamma, gray: gray, rows: rows}
+	if opts.DotThreshold != nil {
+		d.level = *opts.DotThreshold
+	}
+	switch d.method {
+	case DotsDiffuse:
+		d.diffuser = newDiffuser(rows.width, kernels[FloydSteinberg])
+	case DotsOrdered:
+		d.thresholds = thresholdMap(Bayer8)
+	case DotsOtsu:
+		d.level = d.otsu(opts.jobs())
+	}
+	return d
+}
+
+// Returns the gray level of px after the gamma, or false if it's transparent
+func (d *dotter) value(px Pixel) (float64, bool) {
+	if px.alpha < TRANSPARENCY_THRESHOLD {
+		return 0, false
+	}
+	v := d.gray(px.color)
+	if d.gamma != 0 && d.gamma != 1 {
+		v = math.Pow(math.Max(v, 0), 1/d.gamma)
+	}
+	return v, true
+}
+
+// Finds the threshold that maximizes the variance between the gray levels
+// below and above it
+func (d *dotter) otsu(jobs int) float64 {
+	var mu sync.Mutex
+	var histogram [256]int
+	parallelFor(d.rows.height, jobs, func(y int) {
+		row := make([]Pixel, d.rows.width)
+		d.rows.read(y, row)
+		var local [256]int
+		for _, px := range row {
+			if v, ok := d.value(px); ok {
+				local[int(math.Round(math.Min(math.Max(v, 0), 1)*255))]++
+			}
+		}
+		mu.Lock()
+		for i, n := range local {
+			histogram[i] += n
+		}
+		mu.Unlock()
+	})
+
+	total, sum := 0, 0.0
+	for i, n := range histogram {
+		total += n
+		sum += float64(i * n)
+	}
+	best, level := -1.0, 127
+	below, belowSum := 0, 0.0
+	for i, n := range histogram {
+		below += n
+		belowSum += float64(i * n)
+		above := total - below
+		if below == 0 || above == 0 {
+			continue
+		}
+		meanBelow := belowSum / float64(below)
+		meanAbove := (sum - belowSum) / float64(above)
+		if v := float64(below) * float64(above) * sq(meanBelow-meanAbove); v > best {
+			best, level = v, i
+		}
+	}
+	// Lit above the last level of the darker class
+	return (float64(level) + 0.5) / 255
+}
+
+// Lights the dots of the rows starting at first, whose pixels are given.
+// Transparent pixels are never lit
+func (d *dotter) band(first int, pixels [][]Pixel, dots [][]bool, jobs int) {
+	n := len(pixels)
+	switch d.method {
+	case DotsDiffuse:
+		d.diffuser.band(n, jobs, func(x, i int) (lab, bool) {
+			v, ok := d.value(pixels[i][x])
+			dots[i][x] = false
+			return lab{l: v}, ok
+		}, func(x, i int, want lab) lab {
+			quant_error := want.l
+			dots[i][x] = quant_error >= d.level
+			if dots[i][x] {
+				quant_error -= 1.0
+			}
+			return lab{l: quant_error}
+		})
+	case DotsAdaptive:
+		d.adaptive(first, pixels, dots, jobs)
+	default:
+		size := len(d.thresholds)
+		parallelFor(n, jobs, func(i int) {
+			for x, px := range pixels[i] {
+				v, ok := d.value(px)
+				switch {
+				case !ok:
+					dots[i][x] = false
+				case d.thresholds != nil:
+					dots[i][x] = v-d.level+0.5 > d.thresholds[(first+i)%size][x%size]
+				default:
+					dots[i][x] = v > d.level
+				}
+			}
+		})
+	}
+}
+
+// Lights the dots brighter than the mean of the opaque pixels in the square
+// around them
+func (d *dotter) adaptive(first int, pixels [][]Pixel, dots [][]bool, jobs int) {
+	n, width := len(pixels), d.rows.width
+	top := first - adaptiveRadius
+	if top < 0 {
+		top = 0
+	}
+	bottom := first + n + adaptiveRadius
+	if bottom > d.rows.height {
+		bottom = d.rows.height
+	}
+	for len(d.window) < bottom-top {
+		d.window = append(d.window, make([]Pixel, width))
+	}
+	window := d.window[:bottom-top]
+	// Sums and counts of the opaque pixels in each row, across the square
+	sums := make([][]float64, len(window))
+	counts := make([][]int, len(window))
+	parallelFor(len(window), jobs, func(j int) {
+		if y := top + j; y >= first && y < first+n {
+			copy(window[j], pixels[y-first])
+		} else {
+			d.rows.read(y, window[j])
+		}
+		prefix := make([]float64, width+1)
+		opaque := make([]int, width+1)
+		for x, px := range window[j] {
+			v, ok := d.value(px)
+			prefix[x+1], opaque[x+1] = prefix[x], opaque[x]
+			if ok {
+				prefix[x+1] += v
+				opaque[x+1]++
+			}
+		}
+		sums[j], counts[j] = make([]float64, width), make([]int, width)
+		for x := 0; x < width; x++ {
+			left, right := x-adaptiveRadius, x+adaptiveRadius+1
+			if left < 0 {
+				left = 0
+			}
+			if right > width {
+				right = width
+			}
+			sums[j][x] = prefix[right] - prefix[left]
+			counts[j][x] = opaque[right] - opaque[left]
+		}
+	})
+	parallelFor(n, jobs, func(i int) {
+		center := first + i - top
+		from, to := center-adaptiveRadius, center+adaptiveRadius+1
+		if from < 0 {
+			from = 0
+		}
+		if to > len(window) {
+			to = len(window)
+		}
+		for x, px := range pixels[i] {
+			v, ok := d.value(px)
+			if !ok {
+				dots[i][x] = false
+				continue
+			}
+			sum, count := 0.0, 0
+			for j := from; j < to; j++ {
+				sum += sums[j][x]
+				count += counts[j][x]
+			}
+			dots[i][x] = v > sum/float64(count)+d.level-0.5
+		}
+	})
+}
+
+// Bits of the braille dots, by row and column of the 2x4 block. The glyph is
+// U+2800 plus the bits of the dots that are set
+var brailleBits = [4][2]rune{{0x1, 0x8}, {0x2, 0x10}, {0x4, 0x20}, {0x40, 0x80}}
+
+// Returns the braille glyph with the given dots set, blank dots being a space
+// rather than U+2800 since not every font has it
+func brailleGlyph(bits rune) string {
+	if bits == 0 {
+		return " "
+	}
+	return string(0x2800 + bits)
+}
+
+// Writes the image in braille dots without colors to w, one line per row of
+// cells
+func renderBraille(w io.Writer, rows pixelRows, opts Options) error {
+	return layoutBraille(rows, opts, func(cells [][]Cell) error {
+		return encodeRows(w, plainEncoder{}, cells, opts.jobs())
+	})
+}
+
+// Braille layout without colors, from the gray level of the pixels. Blank
+// cells after the last lit dot, both across and down, are trimmed, which
+// means the whole image has to be read before any row is emitted
+func layoutBraille(rows pixelRows, opts Options, emit func([][]Cell) error) error {
+	jobs := opts.jobs()
+	dotter := newDotter(rows, opts, func(c colorful.Color) float64 { return c.R })
+	band := jobs * bandRowsPerJob
+	pixels := make([][]Pixel, band)
+	dots := make([][]bool, band)
+	for i := range pixels {
+		pixels[i] = make([]Pixel, rows.width)
+		dots[i] = make([]bool, rows.width)
+	}
+	// Dots of every cell, a byte each
+	grid := make([][]uint8, (rows.height+3)/4)
+	for i := range grid {
+		grid[i] = make([]uint8, (rows.width+1)/2)
+	}
+	lastRow, lastColumn := 0, 0
+	for first := 0; first < rows.height; first += band {
+		n := rows.height - first
+		if n > band {
+			n = band
+		}
+		parallelFor(n, jobs, func(i int) {
+			rows.read(first+i, pixels[i])
+		})
+		dotter.band(first, pixels[:n], dots[:n], jobs)
+		for i := 0; i < n; i++ {
+			y := first + i
+			for x, dot := range dots[i] {
+				if dot {
+					grid[y/4][x/2] |= uint8(brailleBits[y%4][x%2])
+					if y/4 > lastRow {
+						lastRow = y / 4
+					}
+					if x/2 > lastColumn {
+						lastColumn = x / 2
+					}
+				}
+			}
+		}
+	}
+
+	cells := make([][]Cell, band)
+	for i := range cells {
+		cells[i] = make([]Cell, lastColumn+1)
+	}
+	for first := 0; first <= lastRow; first += band {
+		n := lastRow + 1 - first
+		if n > band {
+			n = band
+		}
+		parallelFor(n, jobs, func(i int) {
+			for x := range cells[i] {
+				var bits rune
+				if first+i < len(grid) && x < len(grid[first+i]) {
+					bits = rune(grid[first+i][x])
+				}
+				cells[i][x] = Cell{Glyph: brailleGlyph(bits)}
+			}
+		})
+		if err := emit(cells[:n]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Gray level of a color, as the braille dots see it
+func luminance(c colorful.Color) float64 {
+	return 0.299*c.R + 0.587*c.G + 0.114*c.B
+}
+
+// Colored braille layout, each cell showing the dots of a 2x4 block in the
+// average color of the lit pixels. Dots are lit following opts.Dots, or with
+// opts.DotBackground by splitting the block at its mean gray level and
+// coloring the unlit dots too. Transparent pixels are never lit
+func layoutDots(rows pixelRows, opts Options, emit func([][]Cell) error) error {
+	jobs := opts.jobs()
+	resolve := colorResolver(opts)
+	band := jobs * bandRowsPerJob * 4
+	pixels := make([][]Pixel, band)
+	dots := make([][]bool, band)
+	for i := range pixels {
+		pixels[i] = make([]Pixel, rows.width)
+		dots[i] = make([]bool, rows.width)
+	}
+	cells := make([][]Cell, band/4)
+	for i := range cells {
+		cells[i] = make([]Cell, (rows.width+1)/2)
+	}
+	var dotter *dotter
+	if !opts.DotBackground {
+		dotter = newDotter(rows, opts, luminance)
+	}
+	for first := 0; first < rows.height; first += band {
+		n := rows.height - first
+		if n > band {
+			n = band
+		}
+		parallelFor(n, jobs, func(i int) {
+			rows.read(first+i, pixels[i])
+		})
+		if dotter != nil {
+			dotter.band(first, pixels[:n], dots[:n], jobs)
+		}
+		count := (n + 3) / 4
+		parallelFor(count, jobs, func(i int) {
+			top, bottom := i*4, i*4+4
+			if bottom > n {
+				bottom = n
+			}
+			var lit [][]bool
+			if dotter != nil {
+				lit = dots[top:bottom]
+			}
+			dotCells(cells[i], pixels[top:bottom], lit, resolve)
+		})
+		if err := emit(cells[:count]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Fills a row of braille cells from up to 4 rows of pixels. lit tells which
+// dots are set, or is nil to split every block in two by its gray level and
+// color the unlit dots as the background
+func dotCells(cells []Cell, pixels [][]Pixel, lit [][]bool, resolve func(colorful.Color) Color) {
+	for cx := range cells {
+		var mean float64
+		opaque := 0
+		if lit == nil {
+			for y := range pixels {
+				for x := cx * 2; x < cx*2+2 && x < len(pixels[y]); x++ {
+					if pixels[y][x].alpha >= TRANSPARENCY_THRESHOLD {
+						mean += luminance(pixels[y][x].color)
+						opaque++
+					}
+				}
+			}
+			if opaque > 0 {
+				mean /= float64(opaque)
+			}
+		}
+
+		var bits rune
+		var fg, bg colorful.Color
+		fgCount, bgCount := 0, 0
+		for y := range pixels {
+			for dx := 0; dx < 2; dx++ {
+				x := cx*2 + dx
+				if x >= len(pixels[y]) || pixels[y][x].alpha < TRANSPARENCY_THRESHOLD {
+					continue
+				}
+				c := pixels[y][x].color
+				var set bool
+				if lit != nil {
+					set = lit[y][x]
+				} else {
+					set = luminance(c) > mean
+				}
+				if set {
+					bits |= brailleBits[y][dx]
+					fg.R, fg.G, fg.B = fg.R+c.R, fg.G+c.G, fg.B+c.B
+					fgCount++
+				} else {
+					bg.R, bg.G, bg.B = bg.R+c.R, bg.G+c.G, bg.B+c.B
+					bgCount++
+				}
+			}
+		}
+
+		cell := Cell{Glyph: brailleGlyph(bits)}
+		if bits != 0 {
+			cell.FG = resolve(average(fg, fgCount))
+		}
+		if lit == nil && bgCount > 0 {
+			cell.BG = resolve(average(bg, bgCount))
+		}
+		cells[cx] = cell
+	}
+}
+
+func average(sum colorful.Color, n int) colorful.Color {
+	return colorful.Color{R: sum.R / float64(n), G: sum.G / float64(n), B: sum.B / float64(n)}
+}
@@ -0,0 +1,166 @@
+package img2term
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+// Returns a width by height grid of pixels of the given gray level
+func grayPixels(width, height int, level uint8) [][]Pixel {
+	pixels := make([][]Pixel, height)
+	for y := range pixels {
+		pixels[y] = make([]Pixel, width)
+		for x := range pixels[y] {
+			pixels[y][x] = MakePixel(color.Gray{level})
+		}
+	}
+	return pixels
+}
+
+func renderBrailleText(t *testing.T, pixels [][]Pixel, opts Options) string {
+	t.Helper()
+	var sb strings.Builder
+	if err := renderBraille(&sb, sliceRows(pixels), opts); err != nil {
+		t.Fatal(err)
+	}
+	return sb.String()
+}
+
+func TestDotThreshold(t *testing.T) {
+	zero, half, one := 0.0, 0.5, 1.0
+	pixels := grayPixels(2, 4, 0x33)
+	for _, test := range []struct {
+		name      string
+		threshold *float64
+		want      string
+	}{
+		{"default", nil, " \n"},
+		{"0.5", &half, " \n"},
+		{"0", &zero, "⣿\n"},
+		{"1", &one, " \n"},
+	} {
+		opts := Options{Dots: DotsThreshold, DotThreshold: test.threshold}
+		if got := renderBrailleText(t, pixels, opts); got != test.want {
+			t.Errorf("threshold %s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+	for _, bad := range []float64{-0.1, 1.1} {
+		opts := Options{Mode: Braille, DotThreshold: &bad}
+		if err := opts.validate(); err == nil {
+			t.Errorf("threshold %v was accepted", bad)
+		}
+	}
+}
+
+// Returns the pixels of a picture drawn with # for white and . for black
+func picturePixels(lines ...string) [][]Pixel {
+	pixels := make([][]Pixel, len(lines))
+	for y, line := range lines {
+		for _, r := range line {
+			level := uint8(0)
+			if r == '#' {
+				level = 0xff
+			}
+			pixels[y] = append(pixels[y], MakePixel(color.Gray{level}))
+		}
+	}
+	return pixels
+}
+
+func TestBrailleGlyph(t *testing.T) {
+	// Dots in the order drawille numbers them, across and then down
+	want := [4][2]string{{"⠁", "⠈"}, {"⠂", "⠐"}, {"⠄", "⠠"}, {"⡀", "⢀"}}
+	var all rune
+	for y, row := range brailleBits {
+		for x, bits := range row {
+			if got := brailleGlyph(bits); got != want[y][x] {
+				t.Errorf("dot %d,%d: got %q, want %q", x, y, got, want[y][x])
+			}
+			all |= bits
+		}
+	}
+	if got := brailleGlyph(0); got != " " {
+		t.Errorf("no dots: got %q, want a space", got)
+	}
+	if got := brailleGlyph(all); got != "⣿" {
+		t.Errorf("every dot: got %q, want ⣿", got)
+	}
+}
+
+func TestLayoutBraille(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		picture []string
+		want    string
+	}{
+		{"blank", []string{"....", "....", "....", "...."}, " \n"},
+		{"full", []string{"##", "##", "##", "##"}, "⣿\n"},
+		{"odd size", []string{"###", "###", "###"}, "⠿⠇\n"},
+		{
+			"trims after the last dot",
+			[]string{
+				"#.......",
+				".#......",
+				"..#.....",
+				"...#....",
+				"........",
+				"........",
+			},
+			"⠑⢄\n",
+		},
+		{
+			"keeps blanks before the last dot",
+			[]string{
+				"......",
+				"......",
+				"......",
+				"......",
+				"......",
+				".....#",
+				"......",
+			},
+			"   \n  ⠐\n",
+		},
+	} {
+		opts := Options{Dots: DotsThreshold}
+		if got := renderBrailleText(t, picturePixels(test.picture...), opts); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+		var cells [][]Cell
+		err := layoutBraille(sliceRows(picturePixels(test.picture...)), opts, func(rows [][]Cell) error {
+			for _, row := range rows {
+				cells = append(cells, append([]Cell(nil), row...))
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var sb strings.Builder
+		for _, row := range cells {
+			for _, cell := range row {
+				sb.WriteString(cell.Glyph)
+			}
+			sb.WriteString("\n")
+		}
+		if got := sb.String(); got != test.want {
+			t.Errorf("%s: cells %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestBrailleModeRejectsGlyphs(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 2, 4))
+	for g := GlyphsBraille; int(g) < len(glyphsNames); g++ {
+		_, err := RenderToText(img, Options{Mode: Braille, Glyphs: g})
+		if !errors.Is(err, ErrInvalidMode) {
+			t.Errorf("glyphs %v: got %v, want ErrInvalidMode", g, err)
+		}
+	}
+	if _, err := RenderToText(img, Options{Mode: Braille}); err != nil {
+		t.Error(err)
+	}
+}
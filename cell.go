@@ -0,0 +1,164 @@
+package img2term
+
+import (
+	"fmt"
+	"image"
+	"strconv"
+
+	"github.com/lucasb-eyer/go-colorful"
+)
+
+// Resolved color of a cell, the zero value is transparent
+type Color struct {
+	Opaque bool
+	Index  int // Palette index, -1 for direct (24-bit) colors
+	RGB    colorful.Color
+}
+
+// Returns the color as it appears in an escape sequence, either the palette
+// index or r;g;b for direct colors
+func (c Color) code() string {
+	if !c.Opaque {
+		return "" // sentinel for transparent colors
+	}
+	if c.Index < 0 {
+		r, g, b := c.RGB.RGB255()
+		return strconv.Itoa(int(r)) + ";" + strconv.Itoa(int(g)) + ";" + strconv.Itoa(int(b))
+	}
+	return strconv.Itoa(c.Index)
+}
+
+// Text attributes of a cell
+type Attr uint8
+
+const (
+	AttrBold Attr = 1 << iota
+	AttrItalic
+	AttrUnderline
+	AttrReverse
+)
+
+// A single character cell of the output. Glyph is drawn in FG over BG
+type Cell struct {
+	Glyph  string
+	FG, BG Color
+	Attrs  Attr
+}
+
+// Lays the image out into a grid of cells after preprocessing it. The grid can
+// be modified and then written out with Encode
+func Layout(img image.Image, opts Options) ([][]Cell, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	if _, ok := graphicsModes[opts.Mode]; ok {
+		return nil, fmt.Errorf("%w: %d", ErrNoCells, opts.Mode)
+	}
+	var grid [][]Cell
+	err := layout(Preprocess(img, opts), opts, func(rows [][]Cell) error {
+		for _, row := range rows {
+			grid = append(grid, append([]Cell(nil), row...))
+		}
+		return nil
+	})
+	return grid, err
+}
+
+// Calls emit with consecutive bands of rows of cells, in order. The rows are
+// reused afterwards
+func layout(img image.Image, opts Options, emit func([][]Cell) error) error {
+	rows := imageRows(img)
+	if opts.Mode == Braille {
+		return layoutBraille(rows, opts, emit)
+	}
+	if opts.FastMatch && opts.CacheDir != "" && opts.Mode != Term24bit {
+		m := matcherFor(opts)
+		m.cacheOnce.Do(func() { m.loadCache(opts.CacheDir) })
+		// The cache is only an optimization, failing to write it is fine
+		defer m.saveCache(opts.CacheDir)
+	}
+	switch opts.Glyphs {
+	case GlyphsBraille:
+		return layoutDots(rows, opts, emit)
+	case GlyphsQuadrant:
+		return layoutMosaic(rows, opts, emit, 2, 2, quadrantGlyph)
+	case GlyphsSextant:
+		return layoutMosaic(rows, opts, emit, 2, 3, sextantGlyph)
+	case GlyphsOctant:
+		return layoutMosaic(rows, opts, emit, 2, 4, octantGlyph)
+	case GlyphsSymbols:
+		return layoutSymbols(rows, opts, emit)
+	case GlyphsShade:
+		return layoutShades(rows, opts, emit)
+	case GlyphsRamp:
+		return layoutRamp(rows, opts, emit)
+	}
+	return layoutBlocks(rows, opts, emit)
+}
+
+// Half block layout, fitting two vertical pixels in each cell (or one pixel in
+// two spaces with UseSpaces). Bands of rows are resolved and laid out on
+// several goroutines
+func layoutBlocks(rows pixelRows, opts Options, emit func([][]Cell) error) error {
+	step := 2
+	if opts.UseSpaces {
+		step = 1
+	}
+	jobs := opts.jobs()
+	band := jobs * bandRowsPerJob * step
+	colors := make([][]Color, band)
+	for i := range colors {
+		colors[i] = make([]Color, rows.width)
+	}
+	cells := make([][]Cell, band/step)
+	for i := range cells {
+		cells[i] = make([]Cell, rows.width)
+	}
+	resolver := newResolver(rows.width, opts)
+	for first := 0; first < rows.height; first += band {
+		n := rows.height - first
+		if n > band {
+			n = band
+		}
+		resolver.resolve(rows, first, colors[:n], jobs)
+		count := (n + step - 1) / step
+		parallelFor(count, jobs, func(i int) {
+			var bottom []Color
+			if step == 2 && i*step+1 < n {
+				bottom = colors[i*step+1]
+			}
+			blockCells(cells[i], colors[i*step], bottom, opts.UseSpaces)
+		})
+		if err := emit(cells[:count]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Fills a row of cells from one or two rows of colors. bottom is nil for the
+// last row of an image with an odd height
+func blockCells(cells []Cell, top, bottom []Color, use_spaces bool) {
+	for x := range cells {
+		cell := Cell{FG: top[x]}
+		if use_spaces {
+			// Two spaces to keep aspect ratio
+			cell.Glyph = "  "
+			cell.FG, cell.BG = Color{}, cell.FG
+		} else {
+			cell.Glyph = "▀"
+			if bottom != nil {
+				cell.BG = bottom[x]
+			}
+			if !cell.FG.Opaque {
+				if !cell.BG.Opaque {
+					cell.Glyph = " "
+				} else {
+					cell.Glyph = "▄"
+				}
+				cell.FG, cell.BG = cell.BG, Color{}
+			}
+		}
+		cells[x] = cell
+	}
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+	"syscall"
+
+	"github.com/lucasb-eyer/go-colorful"
+	"github.com/wwared/img2term"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+var cpuprofile = flag.String("cpuprofile", "", "write cpu profile to `file`")
+var memprofile = flag.String("memprofile", "", "write memory profile to `file`")
+
+func main() {
+	os.Exit(run())
+}
+
+func run() int {
+	flag16 := flag.Bool("16", false, "Use the 16 basic colors")
+	flagIRC := flag.Bool("irc", false, "Output IRC color codes")
+	flagIRC16 := flag.Bool("irc16", false, "Output IRC colors codes (compatibility mode)")
+	flag256 := flag.Bool("256", false, "Use 256 colors")
+	flag24bit := flag.Bool("24bit", false, "Use 24-bit colors")
+	flagBraille := flag.Bool("braille", false, "Use braille characters without colors (see -glyphs for colored ones)")
+	flagSixel := flag.Bool("sixel", false, "Draw the image with sixel graphics")
+	flagKitty := flag.Bool("kitty", false, "Draw the image with the kitty graphics protocol")
+	flagITerm := flag.Bool("iterm", false, "Draw the image with the iTerm2 inline image protocol")
+	flagRaw := flag.Bool("raw", false, "Send uncompressed pixels with -kitty")
+	flagAuto := flag.Bool("auto", true, "Pick the best mode the terminal supports when none is given")
+	flagExplain := flag.Bool("explain", false, "Print why the mode was picked")
+	flagTermPalette := flag.Bool("termpalette", false, "Ask the terminal for its 16 colors instead of assuming the xterm ones")
+	flagPalette := flag.String("palette", "", "Use the 16 colors of a built-in `palette` ("+strings.Join(img2term.PaletteNames(), ", ")+") or of a theme file, when the terminal isn't asked for them")
+	flagDither := flag.String("dither", "none", "Dithering `method` for 16/256 color and IRC output, one of: "+strings.Join(img2term.DitherNames(), ", "))
+	flagMetric := flag.String("metric", "auto", "Color distance `metric` used to match colors to the palette, one of: "+strings.Join(img2term.MetricNames(), ", "))
+	flagFast := flag.Bool("fast", false, "Match colors with a lookup table (faster, but colors may differ slightly)")
+	flagLUTCache := flag.String("lutcache", defaultCacheDir(), "Keep -fast lookup tables in `dir` across runs (empty to disable)")
+	flagJobs := flag.Int("jobs", 0, "Render `n` rows at once (default GOMAXPROCS)")
+	flagFormat := flag.String("format", "", "Output `format`, one of: "+strings.Join(img2term.Encoders(), ", ")+" (default depends on the colors used)")
+
+	flagGlyphs := flag.String("glyphs", "half", "Characters to draw the colors with, one of: "+strings.Join(img2term.GlyphsNames(), ", "))
+	flagDotBG := flag.Bool("dotbg", false, "Color the unlit dots too with -glyphs braille")
+	flagDots := flag.String("dots", "floyd-steinberg", "How braille dots are lit, one of: "+strings.Join(img2term.DotsNames(), ", "))
+	flagThreshold := flag.Float64("threshold", 0.5, "Gray `level` from 0 to 1 braille dots are lit above (an offset from the local mean with -dots adaptive)")
+	flagGamma := flag.Float64("gamma", 1, "Gamma applied to the gray levels before lighting braille dots")
+	flagSymbols := flag.String("symbols", img2term.SymbolsDefault.String(), "Comma separated `sets` of characters -glyphs symbols picks from: "+strings.Join(img2term.SymbolsNames(), ", "))
+	flagRamp := flag.String("ramp", img2term.DefaultRamp, "Characters -glyphs ramp draws gray levels with, from the lightest to the densest (see -format plain for text without colors)")
+	flagAnimated := flag.Bool("animated", false, "Animated GIF playback")
+	flagSpaces := flag.Bool("spaces", false, "Use 2 spaces per pixel instead of fitting two pixels in ▀")
+	flagGrayscale := flag.Bool("gray", false, "Make the image grayscale")
+	flagInvert := flag.Bool("invert", false, "Invert the image colors (useful with -braille)")
+	flagAutocrop := flag.Bool("crop", false, "Automatically crop out same-color or transparent borders")
+	flagAutoresize := flag.Bool("autoresize", false, "Automatically downscale image so it fits your terminal")
+	flagResizeW := flag.Int("width", 0, "Downscale image if wider than `columns` of text (as many dots with -braille, or cells scaled to the terminal's cell size with -sixel, -kitty and -iterm)")
+	flagResizeH := flag.Int("height", 0, "Downscale image if taller than `rows` of text (twice as many dots with -braille, or cells scaled to the terminal's cell size with -sixel, -kitty and -iterm)")
+
+	flag.Parse()
+
+	if *cpuprofile != "" {
+		f, err := os.Create(*cpuprofile)
+		if err != nil {
+			log.Fatal("could not create CPU profile: ", err)
+		}
+		defer f.Close()
+		if err := pprof.StartCPUProfile(f); err != nil {
+			log.Fatal("could not start CPU profile: ", err)
+		}
+		defer pprof.StopCPUProfile()
+	}
+
+	mode := img2term.Term16
+	picked := false
+	setMode := func(m img2term.RenderMode) {
+		if picked {
+			fmt.Print("Only one of -16, -256, -24bit, -irc, -irc16, -braille, -sixel, -kitty or -iterm must be given")
+			os.Exit(1)
+		}
+		mode, picked = m, true
+	}
+	if *flag16 {
+		setMode(img2term.Term16)
+	}
+	if *flag256 {
+		setMode(img2term.Term256)
+	}
+	if *flag24bit {
+		setMode(img2term.Term24bit)
+	}
+	if *flagIRC {
+		setMode(img2term.IRC)
+	}
+	if *flagIRC16 {
+		setMode(img2term.IRC16)
+	}
+	if *flagBraille {
+		setMode(img2term.Braille)
+	}
+	if *flagSixel {
+		setMode(img2term.Sixel)
+	}
+	if *flagKitty {
+		setMode(img2term.Kitty)
+	}
+	if *flagITerm {
+		setMode(img2term.ITerm)
+	}
+	if *flagFormat == "irc" && !picked {
+		// IRC codes can't refer to the terminal palette
+		setMode(img2term.IRC)
+	}
+	reasons := []string{"mode given on the command line"}
+	if !picked && *flagFormat != "" {
+		reasons = []string{"output format given on the command line, using 16 colors"}
+	} else if !picked && *flagAuto {
+		mode, reasons = img2term.Detect(img2term.TerminalEnvironment(os.Stdout))
+	} else if !picked {
+		reasons = []string{"detection disabled, using 16 colors"}
+	}
+	glyphs, err := img2term.ParseGlyphs(*flagGlyphs)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "img2term:", err)
+		return 1
+	}
+	if glyphs != img2term.GlyphsHalf && mode == img2term.Braille {
+		// The braille mode only draws plain dots
+		if picked {
+			fmt.Fprintf(os.Stderr, "img2term: -glyphs %v can't be used with -braille (-glyphs braille alone draws colored dots)\n", glyphs)
+			return 1
+		}
+		mode = img2term.Term16
+		reasons = append(reasons, fmt.Sprintf("-glyphs %v needs colors, using 16 colors", glyphs))
+	}
+	if glyphs == img2term.GlyphsShade && !picked && mode != img2term.Term16 && mode != img2term.IRC16 {
+		// The shades only mix the basic colors, there are too many pairs of the others
+		mode = img2term.Term16
+		reasons = append(reasons, "-glyphs shade mixes the 16 basic colors, using 16 colors")
+	} else if glyphs != img2term.GlyphsHalf && !picked && graphics(mode) {
+		// Glyphs are drawn in cells, which graphics don't have
+		mode = img2term.Term24bit
+		reasons = append(reasons, fmt.Sprintf("-glyphs %v needs cells, using 24-bit colors instead of graphics", glyphs))
+	}
+	if *flagAnimated && graphics(mode) {
+		// Frames are drawn over each other by moving up the lines of text they took
+		if picked {
+			fmt.Fprintln(os.Stderr, "img2term: -animated can't be used with -sixel, -kitty or -iterm")
+			return 1
+		}
+		mode = img2term.Term24bit
+		reasons = append(reasons, "-animated redraws frames in cells, using 24-bit colors instead of graphics")
+	}
+	if *flagAnimated && ((*flagFormat != "" && *flagFormat != "ansi") || mode == img2term.IRC || mode == img2term.IRC16) {
+		fmt.Fprintln(os.Stderr, "img2term: -animated only plays on terminals, not with IRC codes or -format plain")
+		return 1
+	}
+	var palette []colorful.Color
+	if *flagTermPalette {
+		if answer, err := img2term.QueryPalette(img2term.TerminalEnvironment(os.Stdout)); err != nil {
+			reasons = append(reasons, err.Error())
+		} else {
+			reasons = append(reasons, "using the colors the terminal reported")
+			palette = answer.Colors
+		}
+	}
+	if palette == nil && *flagPalette != "" {
+		var err error
+		palette, err = img2term.LoadPalette(*flagPalette)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "img2term:", err)
+			return 1
+		}
+		reasons = append(reasons, fmt.Sprintf("using the colors of %s", *flagPalette))
+	}
+	// Only used for the reset sequence of the terminal, output going anywhere
+	// else gets the plain one
+	var info *img2term.Terminfo
+	if terminal.IsTerminal(int(os.Stdout.Fd())) {
+		if info, err = img2term.LoadTerminfo(os.Getenv("TERM")); err != nil {
+			reasons = append(reasons, fmt.Sprintf("%v, using the default reset sequence", err))
+		}
+	}
+	if *flagExplain {
+		for _, reason := range reasons {
+			fmt.Fprintln(os.Stderr, "img2term:", reason)
+		}
+	}
+	dither, err := img2term.ParseDither(*flagDither)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "img2term:", err)
+		return 1
+	}
+	dots, err := img2term.ParseDots(*flagDots)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "img2term:", err)
+		return 1
+	}
+	metric, err := img2term.ParseMetric(*flagMetric)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "img2term:", err)
+		return 1
+	}
+	symbols, err := img2term.ParseSymbols(*flagSymbols)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "img2term:", err)
+		return 1
+	}
+	w, h := *flagResizeW, *flagResizeH
+	fd := int(os.Stdout.Fd())
+	if *flagAutoresize {
+		w, h, err = terminal.GetSize(fd)
+		if err != nil {
+			log.Fatal(err)
+		}
+		h -= 3 // Some vertical padding for shell prompts
+	}
+	columns, rows := 0, 0
+	if graphics(mode) {
+		// Sizes are in cells, the image is downscaled to the pixels they have
+		columns, rows = w, h
+		cw, ch := cellSize(fd)
+		w, h = w*cw, h*ch
+	} else if glyphs != img2term.GlyphsHalf {
+		cw, ch := glyphs.CellSize()
+		w, h = w*cw, h*ch
+	} else if *flagSpaces {
+		w /= 2
+	} else {
+		h *= 2
+	}
+	opts := img2term.Options{
+		Mode:          mode,
+		Grayscale:     *flagGrayscale,
+		Invert:        *flagInvert,
+		Autocrop:      *flagAutocrop,
+		UseSpaces:     *flagSpaces,
+		Width:         w,
+		Height:        h,
+		Format:        *flagFormat,
+		Dither:        dither,
+		Metric:        metric,
+		Glyphs:        glyphs,
+		DotBackground: *flagDotBG,
+		Dots:          dots,
+		DotThreshold:  flagThreshold,
+		DotGamma:      *flagGamma,
+		Symbols:       symbols,
+		Ramp:          *flagRamp,
+		FastMatch:     *flagFast,
+		CacheDir:      *flagLUTCache,
+		Jobs:          *flagJobs,
+		Columns:       columns,
+		Rows:          rows,
+		RawRGBA:       *flagRaw,
+		Terminfo:      info,
+		Palette:       palette,
+	}
+	if _, err := img2term.NewEncoder(opts); err != nil {
+		fmt.Fprintln(os.Stderr, "img2term:", err)
+		return 1
+	}
+	failed := 0
+	for _, file := range flag.Args() {
+		render := renderFile
+		if *flagAnimated {
+			render = playFile
+		}
+		if err := render(file, opts); errors.Is(err, context.Canceled) {
+			return 130 // Interrupted
+		} else if err != nil {
+			fmt.Fprintln(os.Stderr, "img2term:", err)
+			failed++
+		}
+	}
+
+	if *memprofile != "" {
+		f, err := os.Create(*memprofile)
+		if err != nil {
+			log.Fatal("could not create memory profile: ", err)
+		}
+		defer f.Close()
+		runtime.GC() // get up-to-date statistics
+		if err := pprof.WriteHeapProfile(f); err != nil {
+			log.Fatal("could not write memory profile: ", err)
+		}
+	}
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "img2term: %d of %d files failed\n", failed, len(flag.Args()))
+		return 1
+	}
+	return 0
+}
+
+// Whether mode draws pixels instead of cells
+func graphics(mode img2term.RenderMode) bool {
+	return mode == img2term.Sixel || mode == img2term.Kitty || mode == img2term.ITerm
+}
+
+// Cell size to assume when the terminal doesn't report its size in pixels
+const defaultCellWidth, defaultCellHeight = 10, 20
+
+// Returns the size of a cell of the terminal on fd in pixels
+func cellSize(fd int) (int, int) {
+	columns, lines, err := terminal.GetSize(fd)
+	width, height := terminalPixels(fd)
+	if err != nil || width <= 0 || height <= 0 || columns <= 0 || lines <= 0 {
+		return defaultCellWidth, defaultCellHeight
+	}
+	return width / columns, height / lines
+}
+
+func defaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "img2term")
+}
+
+func renderFile(file string, opts img2term.Options) error {
+	img, err := img2term.DecodeImage(file)
+	if err != nil {
+		return err
+	}
+	if err := img2term.RenderTo(os.Stdout, img, opts); err != nil {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+	return nil
+}
+
+// Plays file if it's a GIF, and renders it like any other image otherwise.
+// Playback is stopped on interrupt
+func playFile(file string, opts img2term.Options) error {
+	g, err := img2term.DecodeGIF(file)
+	if errors.Is(err, img2term.ErrUnsupportedFormat) {
+		return renderFile(file, opts)
+	} else if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+	go func() {
+		select {
+		case <-interrupt:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	if err := img2term.Play(ctx, os.Stdout, g, opts); err != nil {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+	return nil
+}
@@ -1,4 +1,4 @@
-package main
+package img2term
 
 import "github.com/lucasb-eyer/go-colorful"
 
@@ -9,12 +9,15 @@ const TRANSPARENCY_THRESHOLD uint32 = 8192
 type RenderMode int
 
 const (
-	term16    RenderMode = iota
-	term256   RenderMode = iota
-	term24bit RenderMode = iota
-	irc       RenderMode = iota
-	irc16     RenderMode = iota
-	braille   RenderMode = iota
+	Term16    RenderMode = iota
+	Term256   RenderMode = iota
+	Term24bit RenderMode = iota
+	IRC       RenderMode = iota
+	IRC16     RenderMode = iota
+	Braille   RenderMode = iota
+	Sixel     RenderMode = iota
+	Kitty     RenderMode = iota
+	ITerm     RenderMode = iota
 )
 
 // Colors
@@ -1,36 +1,192 @@
-package main
+package img2term
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"image"
 	"image/gif"
 	_ "image/jpeg"
 	_ "image/png"
-	"log"
+	"io"
+	"io/ioutil"
 	"os"
 )
 
-func DecodeImage(path string) image.Image {
-	file, err := os.Open(path)
-	defer file.Close()
+// Error kinds reported by the decoding functions, check for them with errors.Is
+var (
+	ErrUnsupportedFormat = errors.New("unsupported image format")
+	ErrTruncated         = errors.New("truncated image data")
+	ErrCorrupt           = errors.New("corrupt image data")
+	ErrIO                = errors.New("I/O failure")
+	ErrTooLarge          = errors.New("image too large")
+)
+
+// Images with more pixels than this are refused before being decoded
+var MaxPixels = 1 << 26
+
+type DecodeError struct {
+	Path string // Empty when decoding from an io.Reader
+	Kind error  // One of the Err* kinds above
+	Err  error  // Underlying error, may be nil
+}
+
+func (e *DecodeError) Error() string {
+	msg := e.Kind.Error()
+	if e.Path != "" {
+		msg = e.Path + ": " + msg
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *DecodeError) Is(target error) bool {
+	return target == e.Kind
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// Remembers the last read error so that I/O failures can be told apart from
+// decoding failures
+type errReader struct {
+	r   io.ReadSeeker
+	err error
+}
+
+func (er *errReader) Read(p []byte) (int, error) {
+	n, err := er.r.Read(p)
+	if err != nil && err != io.EOF {
+		er.err = err
+	}
+	return n, err
+}
+
+func (er *errReader) Seek(offset int64, whence int) (int64, error) {
+	return er.r.Seek(offset, whence)
+}
+
+func classifyError(er *errReader, err error) error {
+	switch {
+	case er.err != nil:
+		return &DecodeError{Kind: ErrIO, Err: er.err}
+	case errors.Is(err, image.ErrFormat):
+		return &DecodeError{Kind: ErrUnsupportedFormat}
+	// Reaching the end of the data isn't enough, decoders read ahead of
+	// what they need, only their running out of data means it was cut short
+	case errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF):
+		return &DecodeError{Kind: ErrTruncated, Err: err}
+	}
+	return &DecodeError{Kind: ErrCorrupt, Err: err}
+}
+
+func checkSize(er *errReader, decodeConfig func(io.Reader) (image.Config, error)) error {
+	config, err := decodeConfig(er)
+	if err != nil {
+		return classifyError(er, err)
+	}
+	if config.Width*config.Height > MaxPixels {
+		return &DecodeError{Kind: ErrTooLarge, Err: fmt.Errorf("%dx%d exceeds %d pixels", config.Width, config.Height, MaxPixels)}
+	}
+	if _, err := er.Seek(0, io.SeekStart); err != nil {
+		return &DecodeError{Kind: ErrIO, Err: err}
+	}
+	return nil
+}
+
+func decodeConfig(r io.Reader) (image.Config, error) {
+	config, _, err := image.DecodeConfig(r)
+	return config, err
+}
+
+// Like decodeConfig, but only accepts GIFs
+func gifConfig(r io.Reader) (image.Config, error) {
+	config, format, err := image.DecodeConfig(r)
+	if err == nil && format != "gif" {
+		err = image.ErrFormat
+	}
+	return config, err
+}
+
+func seekable(r io.Reader) (io.ReadSeeker, error) {
+	if rs, ok := r.(io.ReadSeeker); ok {
+		return rs, nil
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, &DecodeError{Kind: ErrIO, Err: err}
+	}
+	return bytes.NewReader(data), nil
+}
+
+func withPath(path string, err error) error {
+	var decodeErr *DecodeError
+	if errors.As(err, &decodeErr) {
+		decodeErr.Path = path
+		return decodeErr
+	}
+	return &DecodeError{Path: path, Kind: ErrIO, Err: err}
+}
+
+// Decodes an image in any of the registered formats
+func Decode(r io.Reader) (image.Image, error) {
+	rs, err := seekable(r)
+	if err != nil {
+		return nil, err
+	}
+	er := &errReader{r: rs}
+	if err := checkSize(er, decodeConfig); err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(er)
+	if err != nil {
+		return nil, classifyError(er, err)
+	}
+	return img, nil
+}
+
+// Decodes every frame of a GIF
+func DecodeAllGIF(r io.Reader) (*gif.GIF, error) {
+	rs, err := seekable(r)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	img, _, err := image.Decode(file)
+	er := &errReader{r: rs}
+	if err := checkSize(er, gifConfig); err != nil {
+		return nil, err
+	}
+	img, err := gif.DecodeAll(er)
 	if err != nil {
-		log.Fatal(err)
+		return nil, classifyError(er, err)
 	}
-	return img
+	return img, nil
 }
 
-func DecodeGIF(path string) *gif.GIF {
+func DecodeImage(path string) (image.Image, error) {
 	file, err := os.Open(path)
+	if err != nil {
+		return nil, withPath(path, err)
+	}
 	defer file.Close()
+	img, err := Decode(file)
 	if err != nil {
-		log.Fatal(err)
+		return nil, withPath(path, err)
 	}
-	img, err := gif.DecodeAll(file)
+	return img, nil
+}
+
+func DecodeGIF(path string) (*gif.GIF, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, withPath(path, err)
+	}
+	defer file.Close()
+	img, err := DecodeAllGIF(file)
 	if err != nil {
-		log.Fatal(err)
+		return nil, withPath(path, err)
 	}
-	return img
+	return img, nil
 }
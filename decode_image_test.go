@@ -0,0 +1,68 @@
+package img2term
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func encodedGray(t *testing.T, encode func(*bytes.Buffer, image.Image) error) []byte {
+	img := image.NewGray(image.Rect(0, 0, 8, 8))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 37)
+	}
+	var buf bytes.Buffer
+	if err := encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecodeErrors(t *testing.T) {
+	pngData := encodedGray(t, func(w *bytes.Buffer, img image.Image) error { return png.Encode(w, img) })
+	jpegData := encodedGray(t, func(w *bytes.Buffer, img image.Image) error { return jpeg.Encode(w, img, nil) })
+	// A small file is read to its end by the readahead, damaging it must
+	// still be corruption and not truncation
+	corruptPNG := append([]byte(nil), pngData...)
+	corruptPNG[len(corruptPNG)-20] ^= 0x5a
+	// A GIF frame claiming a local color table it doesn't have makes the
+	// decoder run out of data, though none is missing
+	var buf bytes.Buffer
+	frame := image.NewPaletted(image.Rect(0, 0, 8, 8), color.Palette{color.Black, color.White})
+	if err := gif.Encode(&buf, frame, nil); err != nil {
+		t.Fatal(err)
+	}
+	corruptGIF := buf.Bytes()
+	descriptor := 13 + 3<<(corruptGIF[10]&7+1)
+	if corruptGIF[descriptor] != ',' {
+		t.Fatalf("no image descriptor at %d", descriptor)
+	}
+	corruptGIF[descriptor+9] |= 0x87
+
+	tests := []struct {
+		name string
+		data []byte
+		kind error
+	}{
+		{"png cut in the header", pngData[:30], ErrTruncated},
+		{"png cut before the end", pngData[:len(pngData)-1], ErrTruncated},
+		{"jpeg cut in the middle", jpegData[:len(jpegData)/2], ErrTruncated},
+		{"small corrupt png", corruptPNG, ErrCorrupt},
+		{"gif with a bad color table flag", corruptGIF, ErrCorrupt},
+		{"not an image", []byte("hello, world"), ErrUnsupportedFormat},
+	}
+	for _, test := range tests {
+		_, err := Decode(bytes.NewReader(test.data))
+		if !errors.Is(err, test.kind) {
+			t.Errorf("%s: got %v, want %v", test.name, err, test.kind)
+		}
+	}
+	if _, err := Decode(bytes.NewReader(pngData)); err != nil {
+		t.Errorf("intact png: %v", err)
+	}
+}
@@ -0,0 +1,183 @@
+package img2term
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// How long to wait for the terminal to answer a query
+const queryTimeout = 250 * time.Millisecond
+
+var errQueryTimeout = errors.New("no reply from the terminal")
+
+// What Detect looks at to pick a mode
+type Environment struct {
+	Getenv func(key string) string
+	TTY    bool // Whether the output goes to a terminal
+	// Looks up the terminfo entry of a terminal, nil to go by its name only
+	Terminfo func(name string) (*Terminfo, error)
+	// Sends request to the terminal and returns its reply once done accepts
+	// it. nil if the terminal can't be queried
+	Query func(request string, done func(reply string) bool) (string, error)
+}
+
+// Returns the environment of the process writing to out. The terminal is only
+// queried when out is one
+func TerminalEnvironment(out *os.File) Environment {
+	env := Environment{
+		Getenv:   os.Getenv,
+		TTY:      terminal.IsTerminal(int(out.Fd())),
+		Terminfo: LoadTerminfo,
+	}
+	if env.TTY {
+		env.Query = func(request string, done func(string) bool) (string, error) {
+			return queryTerminal(request, done, queryTimeout)
+		}
+	}
+	return env
+}
+
+// Terminal queries. The kitty graphics query transmits a 1x1 image the
+// terminal only acknowledges, XTVERSION asks for the terminal name and
+// version, and primary device attributes (DA1) go last since practically every
+// terminal answers them, so their reply marks the end of the others
+const (
+	kittyQuery     = "\x1B_Gi=31,s=1,v=1,a=q,t=d,f=24;AAAA\x1B\\"
+	kittyQueryOK   = "\x1B_Gi=31;OK"
+	xtversionQuery = "\x1B[>0q"
+	da1Query       = "\x1B[c"
+)
+
+// Returns the attributes of a DA1 reply in reply, if there is one
+func deviceAttributes(reply string) ([]string, bool) {
+	start := strings.LastIndex(reply, "\x1B[?")
+	if start < 0 {
+		return nil, false
+	}
+	end := strings.IndexByte(reply[start:], 'c')
+	if end < 0 {
+		return nil, false
+	}
+	return strings.Split(reply[start+3:start+end], ";"), true
+}
+
+// Returns the terminal name and version from an XTVERSION reply in reply
+func terminalVersion(reply string) string {
+	start := strings.Index(reply, "\x1BP>|")
+	if start < 0 {
+		return ""
+	}
+	version := reply[start+4:]
+	if end := strings.Index(version, "\x1B\\"); end >= 0 {
+		return version[:end]
+	}
+	return ""
+}
+
+// Picks the best mode the terminal supports, out of kitty graphics, sixel,
+// 24-bit, 256 and 16 colors. Braille is used when colors are unwanted.
+// Reasons explain every step that led to the mode
+func Detect(env Environment) (mode RenderMode, reasons []string) {
+	because := func(format string, args ...interface{}) {
+		reasons = append(reasons, fmt.Sprintf(format, args...))
+	}
+	term, colorterm, program := env.Getenv("TERM"), env.Getenv("COLORTERM"), env.Getenv("TERM_PROGRAM")
+
+	if env.Getenv("NO_COLOR") != "" {
+		because("NO_COLOR is set, using braille without colors")
+		return Braille, reasons
+	}
+	if term == "dumb" {
+		because("TERM is dumb, using braille without colors")
+		return Braille, reasons
+	}
+
+	// Graphics only make sense when the output is shown right away
+	switch {
+	case !env.TTY:
+		because("output is not a terminal, not using graphics or querying it")
+	case env.Query == nil:
+		because("terminal can't be queried")
+	default:
+		reply, err := env.Query(kittyQuery+xtversionQuery+da1Query, func(reply string) bool {
+			_, ok := deviceAttributes(reply)
+			return ok
+		})
+		if err != nil {
+			because("terminal didn't answer the queries: %v", err)
+			break
+		}
+		if strings.Contains(reply, kittyQueryOK) {
+			because("terminal answered the kitty graphics query")
+			return Kitty, reasons
+		}
+		if version := terminalVersion(reply); version != "" {
+			because("terminal identifies itself as %q", version)
+		}
+		attributes, _ := deviceAttributes(reply)
+		for _, attribute := range attributes {
+			if attribute == "4" {
+				because("terminal reports sixel graphics in its device attributes")
+				return Sixel, reasons
+			}
+		}
+		because("terminal didn't report kitty or sixel graphics")
+	}
+	if env.TTY {
+		switch {
+		case term == "xterm-kitty":
+			because("TERM is %q", term)
+			return Kitty, reasons
+		case program == "ghostty":
+			because("TERM_PROGRAM is %q", program)
+			return Kitty, reasons
+		case env.Getenv("KITTY_WINDOW_ID") != "":
+			because("KITTY_WINDOW_ID is set")
+			return Kitty, reasons
+		}
+	}
+
+	switch {
+	case colorterm == "truecolor" || colorterm == "24bit":
+		because("COLORTERM is %q", colorterm)
+		return Term24bit, reasons
+	case program == "iTerm.app" || program == "WezTerm" || program == "vscode":
+		because("TERM_PROGRAM is %q, which has 24-bit colors", program)
+		return Term24bit, reasons
+	}
+
+	if env.Terminfo != nil {
+		info, err := env.Terminfo(term)
+		switch {
+		case err != nil:
+			because("%v, going by the name of the terminal", err)
+		case info.RGB || info.Colors >= 1<<24:
+			because("terminfo entry for %q has direct colors", term)
+			return Term24bit, reasons
+		case info.Colors >= 256:
+			because("terminfo entry for %q has %d colors", term, info.Colors)
+			return Term256, reasons
+		case info.Colors >= 8:
+			because("terminfo entry for %q has %d colors", term, info.Colors)
+			return Term16, reasons
+		default:
+			because("terminfo entry for %q has no colors, using braille", term)
+			return Braille, reasons
+		}
+	}
+	switch {
+	case strings.Contains(term, "256color"):
+		because("TERM is %q", term)
+		return Term256, reasons
+	case program == "Apple_Terminal":
+		because("TERM_PROGRAM is %q, which has 256 colors", program)
+		return Term256, reasons
+	}
+	because("no sign of more than the 16 basic colors")
+	return Term16, reasons
+}
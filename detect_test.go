@@ -0,0 +1,106 @@
+package img2term
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDetect(t *testing.T) {
+	noEntry := func(name string) (*Terminfo, error) { return nil, ErrNoTerminfo }
+	entry := func(colors int, rgb bool) func(string) (*Terminfo, error) {
+		return func(name string) (*Terminfo, error) {
+			return &Terminfo{Names: []string{name}, Colors: colors, RGB: rgb}, nil
+		}
+	}
+	const da1 = "\x1B[?62;22c"
+	for _, test := range []struct {
+		name     string
+		env      map[string]string
+		tty      bool
+		reply    string
+		err      error
+		terminfo func(string) (*Terminfo, error)
+		want     RenderMode
+		reason   string // Part of the last reason
+	}{
+		{name: "NO_COLOR", env: map[string]string{"NO_COLOR": "1", "COLORTERM": "truecolor"}, tty: true, want: Braille, reason: "NO_COLOR"},
+		{name: "dumb", env: map[string]string{"TERM": "dumb"}, tty: true, want: Braille, reason: "dumb"},
+		{
+			name: "not a terminal", env: map[string]string{"TERM": "xterm-kitty"}, terminfo: noEntry,
+			want: Term16, reason: "16 basic colors",
+		},
+		{
+			name: "not a terminal, truecolor", env: map[string]string{"TERM": "xterm-kitty", "COLORTERM": "truecolor"},
+			want: Term24bit, reason: "COLORTERM",
+		},
+		{name: "kitty reply", tty: true, reply: "\x1B_Gi=31;OK\x1B\\" + da1, want: Kitty, reason: "kitty graphics query"},
+		{name: "sixel", tty: true, reply: "\x1BP>|XTerm(390)\x1B\\\x1B[?63;1;2;4;6;9;15;22c", want: Sixel, reason: "sixel"},
+		{name: "sixel first", tty: true, reply: "\x1B[?4;62c", want: Sixel, reason: "sixel"},
+		{
+			name: "no graphics", env: map[string]string{"COLORTERM": "24bit"}, tty: true, reply: da1,
+			want: Term24bit, reason: "COLORTERM",
+		},
+		{
+			name: "attribute 4 is only sixel on its own", env: map[string]string{"TERM": "xterm-256color"}, tty: true,
+			reply: "\x1B[?64;42c", terminfo: noEntry, want: Term256, reason: "TERM",
+		},
+		{
+			name: "timeout", env: map[string]string{"TERM": "xterm-256color"}, tty: true, err: errQueryTimeout,
+			terminfo: noEntry, want: Term256, reason: "TERM",
+		},
+		{name: "kitty TERM", env: map[string]string{"TERM": "xterm-kitty"}, tty: true, err: errQueryTimeout, want: Kitty, reason: "TERM"},
+		{name: "truecolor", env: map[string]string{"COLORTERM": "truecolor"}, tty: true, reply: da1, want: Term24bit, reason: "COLORTERM"},
+		{name: "iTerm", env: map[string]string{"TERM_PROGRAM": "iTerm.app"}, tty: true, reply: da1, want: Term24bit, reason: "TERM_PROGRAM"},
+		{name: "terminfo RGB", env: map[string]string{"TERM": "foot"}, terminfo: entry(256, true), want: Term24bit, reason: "direct colors"},
+		{name: "terminfo direct", env: map[string]string{"TERM": "xterm-direct"}, terminfo: entry(1<<24, false), want: Term24bit, reason: "direct colors"},
+		{name: "terminfo 256", env: map[string]string{"TERM": "screen-256color"}, terminfo: entry(256, false), want: Term256, reason: "256 colors"},
+		{name: "terminfo 8", env: map[string]string{"TERM": "xterm-256color"}, terminfo: entry(8, false), want: Term16, reason: "8 colors"},
+		{name: "terminfo no colors", env: map[string]string{"TERM": "vt100"}, terminfo: entry(-1, false), want: Braille, reason: "no colors"},
+		{name: "name 256", env: map[string]string{"TERM": "xterm-256color"}, want: Term256, reason: "TERM"},
+		{name: "Apple Terminal", env: map[string]string{"TERM_PROGRAM": "Apple_Terminal"}, terminfo: noEntry, want: Term256, reason: "TERM_PROGRAM"},
+		{name: "nothing", env: map[string]string{"TERM": "xterm"}, terminfo: noEntry, want: Term16, reason: "16 basic colors"},
+	} {
+		queried := false
+		env := Environment{
+			Getenv:   func(key string) string { return test.env[key] },
+			TTY:      test.tty,
+			Terminfo: test.terminfo,
+		}
+		if test.tty {
+			env.Query = func(request string, done func(string) bool) (string, error) {
+				queried = true
+				if !strings.HasSuffix(request, da1Query) {
+					t.Errorf("%s: the queries should end with DA1, got %q", test.name, request)
+				}
+				if test.err == nil && !done(test.reply) {
+					t.Errorf("%s: reply %q not accepted", test.name, test.reply)
+				}
+				return test.reply, test.err
+			}
+		}
+		mode, reasons := Detect(env)
+		if mode != test.want {
+			t.Errorf("%s: got mode %d, want %d (%q)", test.name, mode, test.want, reasons)
+		}
+		if len(reasons) == 0 || !strings.Contains(reasons[len(reasons)-1], test.reason) {
+			t.Errorf("%s: reasons %q should end with %q", test.name, reasons, test.reason)
+		}
+		if want := test.tty && test.want != Braille; queried != want {
+			t.Errorf("%s: queried %v, want %v", test.name, queried, want)
+		}
+	}
+}
+
+func TestDetectReplies(t *testing.T) {
+	_, done := deviceAttributes("\x1B_Gi=31;OK\x1B\\")
+	if done {
+		t.Error("a kitty reply alone shouldn't end the queries")
+	}
+	attributes, done := deviceAttributes("\x1BP>|kitty(0.30)\x1B\\\x1B[?62;4c")
+	if !done || strings.Join(attributes, ";") != "62;4" {
+		t.Errorf("got %q, %v", attributes, done)
+	}
+	if version := terminalVersion("\x1BP>|kitty(0.30)\x1B\\"); version != "kitty(0.30)" {
+		t.Errorf("version %q", version)
+	}
+}
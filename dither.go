@@ -0,0 +1,442 @@
+package img2term
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+
+	"github.com/lucasb-eyer/go-colorful"
+)
+
+// Dithering methods for the palette-limited modes
+type Dither int
+
+const (
+	DitherNone Dither = iota
+	FloydSteinberg
+	Atkinson
+	JarvisJudiceNinke
+	Sierra
+	Bayer2
+	Bayer4
+	Bayer8
+	BlueNoise
+)
+
+var ditherNames = []string{
+	DitherNone:        "none",
+	FloydSteinberg:    "floyd-steinberg",
+	Atkinson:          "atkinson",
+	JarvisJudiceNinke: "jjn",
+	Sierra:            "sierra",
+	Bayer2:            "bayer2",
+	Bayer4:            "bayer4",
+	Bayer8:            "bayer8",
+	BlueNoise:         "bluenoise",
+}
+
+func (d Dither) String() string {
+	if d < 0 || int(d) >= len(ditherNames) {
+		return fmt.Sprintf("Dither(%d)", int(d))
+	}
+	return ditherNames[d]
+}
+
+// Returns the names accepted by ParseDither
+func DitherNames() []string {
+	return append([]string(nil), ditherNames...)
+}
+
+func ParseDither(name string) (Dither, error) {
+	for d, n := range ditherNames {
+		if n == name {
+			return Dither(d), nil
+		}
+	}
+	return DitherNone, fmt.Errorf("unknown dithering method %q", name)
+}
+
+//
+// Error diffusion kernels
+//
+
+// Fraction of the quantization error of a pixel that goes to the pixel dx, dy
+// away from it
+type diffusion struct {
+	dx, dy int
+	weight float64
+}
+
+var kernels = map[Dither][]diffusion{
+	FloydSteinberg: {
+		{1, 0, 7.0 / 16.0},
+		{-1, 1, 3.0 / 16.0}, {0, 1, 5.0 / 16.0}, {1, 1, 1.0 / 16.0},
+	},
+	// Only 3/4 of the error is diffused, on purpose
+	Atkinson: {
+		{1, 0, 1.0 / 8.0}, {2, 0, 1.0 / 8.0},
+		{-1, 1, 1.0 / 8.0}, {0, 1, 1.0 / 8.0}, {1, 1, 1.0 / 8.0},
+		{0, 2, 1.0 / 8.0},
+	},
+	JarvisJudiceNinke: {
+		{1, 0, 7.0 / 48.0}, {2, 0, 5.0 / 48.0},
+		{-2, 1, 3.0 / 48.0}, {-1, 1, 5.0 / 48.0}, {0, 1, 7.0 / 48.0}, {1, 1, 5.0 / 48.0}, {2, 1, 3.0 / 48.0},
+		{-2, 2, 1.0 / 48.0}, {-1, 2, 3.0 / 48.0}, {0, 2, 5.0 / 48.0}, {1, 2, 3.0 / 48.0}, {2, 2, 1.0 / 48.0},
+	},
+	Sierra: {
+		{1, 0, 5.0 / 32.0}, {2, 0, 3.0 / 32.0},
+		{-2, 1, 2.0 / 32.0}, {-1, 1, 4.0 / 32.0}, {0, 1, 5.0 / 32.0}, {1, 1, 4.0 / 32.0}, {2, 1, 2.0 / 32.0},
+		{-1, 2, 2.0 / 32.0}, {0, 2, 3.0 / 32.0}, {1, 2, 2.0 / 32.0},
+	},
+}
+
+// Returns the kernel entries sorted so that the error a pixel receives is
+// gathered from the pixels around it in the order they were quantized
+func gatherOrder(kernel []diffusion) []diffusion {
+	sorted := append([]diffusion(nil), kernel...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].dy != sorted[j].dy {
+			return sorted[i].dy > sorted[j].dy
+		}
+		return sorted[i].dx > sorted[j].dx
+	})
+	return sorted
+}
+
+//
+// Ordered dithering threshold maps, with values in [0, 1)
+//
+
+func bayerMatrix(n int) [][]float64 {
+	m := [][]int{{0}}
+	for size := 1; size < n; size *= 2 {
+		next := make([][]int, size*2)
+		for y := range next {
+			next[y] = make([]int, size*2)
+			for x := range next[y] {
+				v := 4 * m[y%size][x%size]
+				switch {
+				case y < size && x >= size:
+					v += 2
+				case y >= size && x < size:
+					v += 3
+				case y >= size && x >= size:
+					v += 1
+				}
+				next[y][x] = v
+			}
+		}
+		m = next
+	}
+	return normalizeRanks(m)
+}
+
+func normalizeRanks(m [][]int) [][]float64 {
+	total := float64(len(m) * len(m))
+	result := make([][]float64, len(m))
+	for y := range m {
+		result[y] = make([]float64, len(m[y]))
+		for x, v := range m[y] {
+			result[y][x] = (float64(v) + 0.5) / total
+		}
+	}
+	return result
+}
+
+var (
+	blueNoiseOnce   sync.Once
+	blueNoiseMatrix [][]float64
+)
+
+// Generates a blue noise threshold map with the void-and-cluster method
+func blueNoise() [][]float64 {
+	blueNoiseOnce.Do(func() {
+		const size = 32
+		const sigma = 1.5
+		n := size * size
+
+		// Toroidal gaussian weights by offset
+		weights := make([]float64, n)
+		for dy := 0; dy < size; dy++ {
+			for dx := 0; dx < size; dx++ {
+				wx, wy := math.Min(float64(dx), float64(size-dx)), math.Min(float64(dy), float64(size-dy))
+				weights[dy*size+dx] = math.Exp(-(wx*wx + wy*wy) / (2 * sigma * sigma))
+			}
+		}
+		weight := func(p, q int) float64 {
+			dx := (p%size - q%size + size) % size
+			dy := (p/size - q/size + size) % size
+			return weights[dy*size+dx]
+		}
+
+		pattern := make([]bool, n)
+		energy := make([]float64, n)
+		toggle := func(p int) {
+			pattern[p] = !pattern[p]
+			sign := 1.0
+			if !pattern[p] {
+				sign = -1.0
+			}
+			for q := range energy {
+				energy[q] += sign * weight(p, q)
+			}
+		}
+		tightestCluster := func() int {
+			best := -1
+			for p := range pattern {
+				if pattern[p] && (best < 0 || energy[p] > energy[best]) {
+					best = p
+				}
+			}
+			return best
+		}
+		largestVoid := func() int {
+			best := -1
+			for p := range pattern {
+				if !pattern[p] && (best < 0 || energy[p] < energy[best]) {
+					best = p
+				}
+			}
+			return best
+		}
+
+		// Start from a random pattern and move points from clusters into
+		// voids until they are evenly spread
+		rng := rand.New(rand.NewSource(1))
+		ones := n / 10
+		for _, p := range rng.Perm(n)[:ones] {
+			toggle(p)
+		}
+		for {
+			cluster := tightestCluster()
+			toggle(cluster)
+			void := largestVoid()
+			toggle(void)
+			if void == cluster {
+				break
+			}
+		}
+		initial := append([]bool(nil), pattern...)
+		initialEnergy := append([]float64(nil), energy...)
+
+		ranks := make([][]int, size)
+		for y := range ranks {
+			ranks[y] = make([]int, size)
+		}
+		for rank := ones - 1; rank >= 0; rank-- {
+			p := tightestCluster()
+			toggle(p)
+			ranks[p/size][p%size] = rank
+		}
+		copy(pattern, initial)
+		copy(energy, initialEnergy)
+		for rank := ones; rank < n; rank++ {
+			p := largestVoid()
+			toggle(p)
+			ranks[p/size][p%size] = rank
+		}
+		blueNoiseMatrix = normalizeRanks(ranks)
+	})
+	return blueNoiseMatrix
+}
+
+func thresholdMap(dither Dither) [][]float64 {
+	switch dither {
+	case Bayer2:
+		return bayerMatrix(2)
+	case Bayer4:
+		return bayerMatrix(4)
+	case Bayer8:
+		return bayerMatrix(8)
+	case BlueNoise:
+		return blueNoise()
+	}
+	return nil
+}
+
+//
+// Color resolution
+//
+
+type lab struct {
+	l, a, b float64
+}
+
+func toLab(c colorful.Color) lab {
+	l, a, b := c.Lab()
+	return lab{l, a, b}
+}
+
+// Error diffusion over bands of consecutive rows. Every pixel pulls the error
+// of the already quantized pixels around it, so the rows of a band can be
+// processed concurrently with each one trailing behind the row above
+type diffuser struct {
+	width  int
+	kernel []diffusion // In gather order
+	lag    int
+	errs   [][]lab // Quantization errors of the last rows of the previous band, then this band
+	carry  int     // Rows of errs from the previous band
+}
+
+func newDiffuser(width int, kernel []diffusion) *diffuser {
+	kernel = gatherOrder(kernel)
+	return &diffuser{width: width, kernel: kernel, lag: kernelLag(kernel)}
+}
+
+// Diffuses the error into the next n rows. pixel returns the color a pixel
+// should have, or false if it neither receives nor spreads any error, and
+// quantize returns the error left after quantizing the pixel with the diffused
+// error added. Rows are given by their index in the band
+func (d *diffuser) band(n, jobs int, pixel func(x, i int) (lab, bool), quantize func(x, i int, want lab) lab) {
+	used := d.carry + n
+	for len(d.errs) < used {
+		d.errs = append(d.errs, make([]lab, d.width))
+	}
+	errs := d.errs[:used]
+	wf := newWavefront(n, d.width, d.lag)
+	parallelFor(n, jobs, func(i int) {
+		row := d.carry + i
+		cur := errs[row]
+		for x := range cur {
+			wf.wait(i, x)
+			cur[x] = lab{}
+			if want, ok := pixel(x, i); ok {
+				for _, k := range d.kernel {
+					sx, sy := x-k.dx, row-k.dy
+					if sx < 0 || sx >= d.width || sy < 0 {
+						continue
+					}
+					e := errs[sy][sx]
+					want.l += e.l * k.weight
+					want.a += e.a * k.weight
+					want.b += e.b * k.weight
+				}
+				cur[x] = quantize(x, i, want)
+			}
+			wf.done(i, x)
+		}
+	})
+
+	// Keep the rows the next band pulls error from, reusing the others
+	keep := d.kernel[0].dy
+	if keep > used {
+		keep = used
+	}
+	rows := make([][]lab, 0, len(d.errs))
+	rows = append(rows, d.errs[used-keep:used]...)
+	rows = append(rows, d.errs[:used-keep]...)
+	d.errs, d.carry = append(rows, d.errs[used:]...), keep
+}
+
+// Resolves pixels to the closest color of the palette of a mode, dithering in
+// Lab space. Transparent pixels neither receive nor spread any error
+type resolver struct {
+	width   int
+	nearest func(colorful.Color) Color
+	direct  bool // No dithering
+
+	diffuser *diffuser
+
+	thresholds [][]float64
+	spread     float64
+
+	pixels [][]Pixel
+}
+
+func newResolver(width int, opts Options) *resolver {
+	if opts.Mode == Term24bit {
+		return newPaletteResolver(width, opts, colorResolver(opts), nil)
+	}
+	m := matcherFor(opts)
+	return newPaletteResolver(width, opts, m.resolver(opts.FastMatch), m.spread)
+}
+
+// Like newResolver, for a palette other than the one of opts.Mode. spread is
+// only called if ordered dithering needs it
+func newPaletteResolver(width int, opts Options, nearest func(colorful.Color) Color, spread func() float64) *resolver {
+	r := &resolver{width: width, nearest: nearest}
+	kernel, diffuse := kernels[opts.Dither]
+	switch {
+	case opts.Mode == Term24bit || opts.Dither == DitherNone:
+		r.direct = true
+	case diffuse:
+		r.diffuser = newDiffuser(width, kernel)
+	default:
+		r.thresholds = thresholdMap(opts.Dither)
+		r.spread = spread()
+	}
+	return r
+}
+
+// Resolves the rows starting at first into out. Consecutive calls must follow
+// each other down the image
+func (r *resolver) resolve(rows pixelRows, first int, out [][]Color, jobs int) {
+	for len(r.pixels) < len(out) {
+		r.pixels = append(r.pixels, make([]Pixel, r.width))
+	}
+	pixels := r.pixels
+	parallelFor(len(out), jobs, func(i int) {
+		rows.read(first+i, pixels[i])
+	})
+
+	if r.diffuser != nil {
+		r.diffuser.band(len(out), jobs, func(x, i int) (lab, bool) {
+			px := pixels[i][x]
+			if px.alpha < TRANSPARENCY_THRESHOLD {
+				out[i][x] = Color{}
+				return lab{}, false
+			}
+			return toLab(px.color), true
+		}, func(x, i int, want lab) lab {
+			out[i][x] = r.nearest(colorful.Lab(want.l, want.a, want.b).Clamped())
+			got := toLab(out[i][x].RGB)
+			return lab{want.l - got.l, want.a - got.a, want.b - got.b}
+		})
+		return
+	}
+
+	n := len(r.thresholds)
+	parallelFor(len(out), jobs, func(i int) {
+		y := first + i
+		for x, px := range pixels[i] {
+			out[i][x] = Color{}
+			if px.alpha < TRANSPARENCY_THRESHOLD {
+				continue
+			}
+			if r.direct {
+				out[i][x] = r.nearest(px.color)
+				continue
+			}
+			// Offset the chroma by other parts of the map so that the
+			// three channels aren't correlated
+			want := toLab(px.color)
+			want.l += r.spread * (r.thresholds[y%n][x%n] - 0.5)
+			want.a += r.spread * (r.thresholds[(y+n/2)%n][x%n] - 0.5) / 2
+			want.b += r.spread * (r.thresholds[y%n][(x+n/2)%n] - 0.5) / 2
+			out[i][x] = r.nearest(colorful.Lab(want.l, want.a, want.b).Clamped())
+		}
+	})
+}
+
+// Twice the average Lab distance between each palette color and its nearest
+// neighbour, used as the amplitude of ordered dithering
+func spreadOf(palette []colorful.Color) float64 {
+	if len(palette) == 0 {
+		return 0
+	}
+	total := 0.0
+	for i := range palette {
+		nearest := math.Inf(1)
+		for j := range palette {
+			if d := palette[i].Clamped().DistanceLab(palette[j].Clamped()); i != j && d > 0 && d < nearest {
+				nearest = d
+			}
+		}
+		if !math.IsInf(nearest, 1) {
+			total += nearest
+		}
+	}
+	return 2 * total / float64(len(palette))
+}
@@ -0,0 +1,315 @@
+package img2term
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestKernelWeights(t *testing.T) {
+	for dither, kernel := range kernels {
+		sum := 0.0
+		for _, k := range kernel {
+			if k.dy < 0 || (k.dy == 0 && k.dx <= 0) {
+				t.Errorf("%v: error goes to %d, %d, which is already quantized", dither, k.dx, k.dy)
+			}
+			sum += k.weight
+		}
+		want := 1.0
+		if dither == Atkinson {
+			want = 0.75
+		}
+		if math.Abs(sum-want) > 1e-12 {
+			t.Errorf("%v: weights sum to %v, want %v", dither, sum, want)
+		}
+	}
+}
+
+func TestGatherOrder(t *testing.T) {
+	lags := map[Dither]int{FloydSteinberg: 1, Atkinson: 1, JarvisJudiceNinke: 2, Sierra: 2}
+	for dither, kernel := range kernels {
+		sorted := gatherOrder(kernel)
+		if len(sorted) != len(kernel) {
+			t.Fatalf("%v: %d entries sorted, want %d", dither, len(sorted), len(kernel))
+		}
+		// Pixels from rows further up, then from further left, were quantized
+		// first
+		for i := 1; i < len(sorted); i++ {
+			p, k := sorted[i-1], sorted[i]
+			if p.dy < k.dy || (p.dy == k.dy && p.dx <= k.dx) {
+				t.Errorf("%v: %v comes before %v", dither, p, k)
+			}
+		}
+		for _, k := range kernel {
+			found := false
+			for _, s := range sorted {
+				found = found || s == k
+			}
+			if !found {
+				t.Errorf("%v: %v lost when sorting", dither, k)
+			}
+		}
+		if lag := kernelLag(kernel); lag != lags[dither] {
+			t.Errorf("%v: lag %d, want %d", dither, lag, lags[dither])
+		}
+	}
+}
+
+// Checks that m is an n by n map of the ranks 0 to n²-1, centered in their
+// cells
+func checkRanks(t *testing.T, name string, m [][]float64, n int) {
+	t.Helper()
+	if len(m) != n {
+		t.Fatalf("%s: %d rows, want %d", name, len(m), n)
+	}
+	total := float64(n * n)
+	seen := make([]bool, n*n)
+	for y, row := range m {
+		if len(row) != n {
+			t.Fatalf("%s: row %d has %d values, want %d", name, y, len(row), n)
+		}
+		for x, v := range row {
+			rank := v*total - 0.5
+			r := int(math.Round(rank))
+			if v <= 0 || v >= 1 || math.Abs(rank-float64(r)) > 1e-9 || seen[r] {
+				t.Fatalf("%s: %v at %d, %d isn't a new rank", name, v, x, y)
+			}
+			seen[r] = true
+		}
+	}
+}
+
+func TestThresholdMaps(t *testing.T) {
+	bayer2 := [][]float64{{0.125, 0.625}, {0.875, 0.375}}
+	m := thresholdMap(Bayer2)
+	for y := range bayer2 {
+		for x := range bayer2[y] {
+			if m[y][x] != bayer2[y][x] {
+				t.Fatalf("bayer2 is %v, want %v", m, bayer2)
+			}
+		}
+	}
+	for dither, n := range map[Dither]int{Bayer2: 2, Bayer4: 4, Bayer8: 8, BlueNoise: 32} {
+		checkRanks(t, dither.String(), thresholdMap(dither), n)
+	}
+
+	// Each Bayer map repeats the smaller one in every quadrant, scaled
+	for _, n := range []int{4, 8} {
+		big, small := bayerMatrix(n), bayerMatrix(n/2)
+		for y := range big {
+			for x := range big[y] {
+				got := int(big[y][x]*float64(n*n)) / 4
+				want := int(small[y%(n/2)][x%(n/2)] * float64(n*n/4))
+				if got != want {
+					t.Fatalf("bayer%d at %d, %d doesn't follow bayer%d", n, x, y, n/2)
+				}
+			}
+		}
+	}
+
+	// Close thresholds of blue noise are spread out, unlike white noise
+	noise := blueNoise()
+	for y := range noise {
+		for x := range noise[y] {
+			for _, d := range [][2]int{{1, 0}, {0, 1}} {
+				if math.Abs(noise[y][x]-noise[(y+d[1])%32][(x+d[0])%32]) < 1.0/1024 {
+					t.Fatalf("blue noise has neighbouring ranks at %d, %d", x, y)
+				}
+			}
+		}
+	}
+	if thresholdMap(FloydSteinberg) != nil || thresholdMap(DitherNone) != nil {
+		t.Error("threshold map for a method without one")
+	}
+}
+
+// Diffuses error the textbook way, pushing the error of every pixel forward
+// to its neighbours
+func scatterDiffuse(kernel []diffusion, opaque [][]bool, base lab, quantize func(lab) lab) [][]lab {
+	height, width := len(opaque), len(opaque[0])
+	errs := make([][]lab, height)
+	wants := make([][]lab, height)
+	for y := range errs {
+		errs[y] = make([]lab, width)
+		wants[y] = make([]lab, width)
+		for x := range wants[y] {
+			wants[y][x] = base
+		}
+	}
+	for y := range opaque {
+		for x := range opaque[y] {
+			if !opaque[y][x] {
+				continue
+			}
+			e := quantize(wants[y][x])
+			for _, k := range kernel {
+				tx, ty := x+k.dx, y+k.dy
+				if tx < 0 || tx >= width || ty >= height || !opaque[ty][tx] {
+					continue
+				}
+				wants[ty][tx].l += e.l * k.weight
+				wants[ty][tx].a += e.a * k.weight
+				wants[ty][tx].b += e.b * k.weight
+			}
+		}
+	}
+	return wants
+}
+
+func TestDiffuserTransparency(t *testing.T) {
+	base := lab{50, 10, -10}
+	step := func(want lab) lab {
+		// Error of rounding to a coarse grid of colors
+		q := func(v float64) float64 { return v - 16*math.Round(v/16) }
+		return lab{q(want.l), q(want.a), q(want.b)}
+	}
+
+	// With the error of every pixel known, a transparent pixel must not
+	// change its right neighbour in the first row
+	d := newDiffuser(3, kernels[FloydSteinberg])
+	var wants [2][3]*lab
+	d.band(2, 1, func(x, i int) (lab, bool) {
+		return base, !(x == 1 && i == 0)
+	}, func(x, i int, want lab) lab {
+		wants[i][x] = &want
+		return lab{16, 0, 0}
+	})
+	for i, row := range [][]float64{{0, -1, 0}, {5, 11, 12}} {
+		for x, l := range row {
+			got := wants[i][x]
+			if l < 0 {
+				if got != nil {
+					t.Errorf("transparent pixel quantized to %v", *got)
+				}
+				continue
+			}
+			if got == nil || math.Abs(got.l-base.l-l) > 1e-9 || got.a != base.a || got.b != base.b {
+				t.Errorf("pixel %d, %d got %v, want %v more lightness than %v", x, i, got, l, base)
+			}
+		}
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	const width, height = 23, 29
+	opaque := make([][]bool, height)
+	for y := range opaque {
+		opaque[y] = make([]bool, width)
+		for x := range opaque[y] {
+			opaque[y][x] = rng.Intn(4) > 0
+		}
+	}
+	for dither, kernel := range kernels {
+		want := scatterDiffuse(kernel, opaque, base, step)
+		for _, jobs := range []int{1, 4} {
+			d := newDiffuser(width, kernel)
+			got := make([][]lab, height)
+			for y := range got {
+				got[y] = make([]lab, width)
+			}
+			// Bands of a few rows, so the error is carried over between them
+			for first := 0; first < height; first += 5 {
+				n := height - first
+				if n > 5 {
+					n = 5
+				}
+				d.band(n, jobs, func(x, i int) (lab, bool) {
+					return base, opaque[first+i][x]
+				}, func(x, i int, want lab) lab {
+					got[first+i][x] = want
+					return step(want)
+				})
+			}
+			for y := range got {
+				for x := range got[y] {
+					if !opaque[y][x] {
+						continue
+					}
+					g, w := got[y][x], want[y][x]
+					if math.Abs(g.l-w.l)+math.Abs(g.a-w.a)+math.Abs(g.b-w.b) > 1e-9 {
+						t.Fatalf("%v with %d jobs: pixel %d, %d got %v, want %v", dither, jobs, x, y, g, w)
+					}
+				}
+			}
+		}
+	}
+}
+
+// An image with smooth gradients for dithering to show, and transparent spots
+func ditherImage(width, height int) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := color.NRGBA{uint8(x * 255 / width), uint8(y * 255 / height), uint8((x + y) * 3), 0xff}
+			if (x/7+y/5)%9 == 0 {
+				c.A = 0
+			}
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestResolverTransparency(t *testing.T) {
+	// The color of transparent pixels doesn't matter, so it can't spread
+	// any error either
+	const width, height = 20, 12
+	rows := func(hidden color.Color) pixelRows {
+		pixels := make([][]Pixel, height)
+		for y := range pixels {
+			pixels[y] = make([]Pixel, width)
+			for x := range pixels[y] {
+				c := MakePixel(color.NRGBA{uint8(x * 12), 0x80, uint8(y * 20), 0xff})
+				if (x+y)%5 == 0 {
+					c = MakePixel(hidden)
+				}
+				pixels[y][x] = c
+			}
+		}
+		return sliceRows(pixels)
+	}
+	for dither := range ditherNames {
+		opts := Options{Mode: Term16, Dither: Dither(dither)}
+		var outs [2][][]Color
+		for i, hidden := range []color.Color{color.NRGBA{0xff, 0, 0, 0}, color.NRGBA{0, 0, 0xff, 0x10}} {
+			outs[i] = make([][]Color, height)
+			for y := range outs[i] {
+				outs[i][y] = make([]Color, width)
+			}
+			newResolver(width, opts).resolve(rows(hidden), 0, outs[i], 1)
+		}
+		for y := range outs[0] {
+			for x, c := range outs[0][y] {
+				if c != outs[1][y][x] {
+					t.Fatalf("%v: pixel %d, %d changed with the transparent pixels", Dither(dither), x, y)
+				}
+				if (x+y)%5 == 0 && c != (Color{}) {
+					t.Fatalf("%v: transparent pixel %d, %d resolved to %v", Dither(dither), x, y, c)
+				}
+			}
+		}
+	}
+}
+
+func TestDitherJobs(t *testing.T) {
+	// Enough rows for several bands of 8 jobs
+	img := ditherImage(61, 8*bandRowsPerJob*2*3+5)
+	for _, mode := range []RenderMode{Term16, Term256, IRC, IRC16} {
+		for dither := range ditherNames {
+			opts := Options{Mode: mode, Dither: Dither(dither), Jobs: 1}
+			serial, err := RenderToText(img, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			opts.Jobs = 8
+			parallel, err := RenderToText(img, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if serial != parallel {
+				t.Errorf("mode %d with %v: output differs between 1 and 8 jobs", mode, Dither(dither))
+			}
+		}
+	}
+}
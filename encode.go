@@ -0,0 +1,380 @@
+package img2term
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Writes rows of cells in some output format. Begin and End are called once
+// around the rows of every image. EncodeRow may be called for several rows of
+// the same image at once, each with its own writer, and the output is then
+// written in order
+type Encoder interface {
+	Begin(w io.Writer) error
+	EncodeRow(w io.Writer, row []Cell) error
+	End(w io.Writer) error
+}
+
+var (
+	ErrUnknownFormat = errors.New("unknown output format")
+	ErrFormatMode    = errors.New("output format can't show the colors of the render mode")
+)
+
+var (
+	encodersMu sync.RWMutex
+	encoders   = map[string]func(Options) Encoder{}
+)
+
+// Makes an encoder available under name, so it can be selected with
+// Options.Format. The factory is called once per rendered image
+func RegisterEncoder(name string, factory func(Options) Encoder) {
+	encodersMu.Lock()
+	defer encodersMu.Unlock()
+	encoders[name] = factory
+}
+
+// Returns the names of all registered encoders, sorted
+func Encoders() []string {
+	encodersMu.RLock()
+	defer encodersMu.RUnlock()
+	names := make([]string, 0, len(encoders))
+	for name := range encoders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Returns the encoder selected by opts.Format, or the default one for
+// opts.Mode if no format was given
+func NewEncoder(opts Options) (Encoder, error) {
+	name := opts.Format
+	if name == "" {
+		name = defaultFormat(opts.Mode)
+	}
+	encodersMu.RLock()
+	factory, ok := encoders[name]
+	encodersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownFormat, name)
+	}
+	if err := checkFormat(opts); err != nil {
+		return nil, err
+	}
+	return factory(opts), nil
+}
+
+// Checks that the built-in format opts.Format can show the colors of
+// opts.Mode. IRC codes only refer to the IRC palettes, and ANSI sequences to
+// the terminal ones. Other formats are up to their encoders
+func checkFormat(opts Options) error {
+	irc := opts.Mode == IRC || opts.Mode == IRC16
+	if (opts.Format == "irc" && !irc && opts.Mode != Braille) || (opts.Format == "ansi" && irc) {
+		return fmt.Errorf("%w: %s for mode %d", ErrFormatMode, opts.Format, opts.Mode)
+	}
+	return nil
+}
+
+func defaultFormat(mode RenderMode) string {
+	switch mode {
+	case IRC, IRC16:
+		return "irc"
+	case Braille:
+		return "plain"
+	}
+	return "ansi"
+}
+
+func init() {
+	RegisterEncoder("ansi", func(opts Options) Encoder { return ansiEncoder{reset: opts.reset()} })
+	RegisterEncoder("irc", func(Options) Encoder { return ircEncoder{} })
+	RegisterEncoder("plain", func(Options) Encoder { return plainEncoder{} })
+}
+
+// Encodes a grid of cells with the encoder selected by opts
+func Encode(w io.Writer, grid [][]Cell, opts Options) error {
+	if err := opts.validate(); err != nil {
+		return err
+	}
+	enc, err := NewEncoder(opts)
+	if err != nil {
+		return err
+	}
+	buffer := bufio.NewWriter(w)
+	if err := enc.Begin(buffer); err != nil {
+		return err
+	}
+	if err := encodeRows(buffer, enc, grid, opts.jobs()); err != nil {
+		return err
+	}
+	if err := enc.End(buffer); err != nil {
+		return err
+	}
+	return buffer.Flush()
+}
+
+// Renders the pixels in half blocks, or returns an empty string if they can't
+// be rendered in mode
+func Render(mode RenderMode, use_spaces bool, colors [][]Pixel) string {
+	var sb strings.Builder
+	opts := Options{Mode: mode, UseSpaces: use_spaces}
+	// Only the color modes have half blocks
+	if err := opts.validate(); err != nil || mode > IRC16 {
+		return ""
+	}
+	enc, err := NewEncoder(opts)
+	if err != nil {
+		return ""
+	}
+	err = layoutBlocks(sliceRows(colors), opts, func(rows [][]Cell) error {
+		return encodeRows(&sb, enc, rows, opts.jobs())
+	})
+	if err != nil {
+		return ""
+	}
+	return sb.String()
+}
+
+// Encodes the rows concurrently and writes them to w in order
+func encodeRows(w io.Writer, enc Encoder, rows [][]Cell, jobs int) error {
+	encoded := make([]bytes.Buffer, len(rows))
+	errs := make([]error, len(rows))
+	parallelFor(len(rows), jobs, func(i int) {
+		errs[i] = enc.EncodeRow(&encoded[i], rows[i])
+	})
+	for i := range encoded {
+		if errs[i] != nil {
+			return errs[i]
+		}
+		if _, err := encoded[i].WriteTo(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//
+// ANSI escape sequences
+//
+
+// Returns the sequence that clears all colors and attributes on the terminal
+func (opts Options) reset() string {
+	if opts.Terminfo != nil && opts.Terminfo.SGR0 != "" {
+		return opts.Terminfo.SGR0
+	}
+	return "\x1B[0m"
+}
+
+// Start of the sequence setting the foreground color in mode, to be followed
+// by the color code and EndColor
+//
+// Deprecated: lay the image out in cells and write them with an Encoder
+func StartFGColor(mode RenderMode) string {
+	return startColor(mode, "38", "\x03")
+}
+
+// Same as StartFGColor, for the background color
+//
+// Deprecated: lay the image out in cells and write them with an Encoder
+func StartBGColor(mode RenderMode) string {
+	return startColor(mode, "48", ",")
+}
+
+func startColor(mode RenderMode, selector, irc string) string {
+	if mode == IRC || mode == IRC16 {
+		return irc
+	}
+	if mode == Term24bit {
+		return "\x1B[" + selector + ";2;"
+	}
+	return "\x1B[" + selector + ";5;"
+}
+
+// End of the sequences started by StartFGColor and StartBGColor
+//
+// Deprecated: lay the image out in cells and write them with an Encoder
+func EndColor(mode RenderMode) string {
+	if mode == IRC || mode == IRC16 {
+		return ""
+	}
+	// ANSI escape sequences are terminated by 'm'
+	return "m"
+}
+
+// Sequence clearing the colors in mode
+//
+// Deprecated: lay the image out in cells and write them with an Encoder
+func Clear(mode RenderMode) string {
+	if mode == IRC || mode == IRC16 {
+		return ""
+	}
+	return Options{Mode: mode}.reset()
+}
+
+type ansiEncoder struct {
+	reset string // Sequence that clears all colors and attributes
+}
+
+// SGR parameters for each Attr, in bit order
+var ansiAttrCodes = []string{"1", "3", "4", "7"}
+
+// Foreground color selector is 38, background is 48
+func ansiColor(selector string, c Color) string {
+	if c.Index < 0 {
+		return "\x1B[" + selector + ";2;" + c.code() + "m"
+	}
+	return "\x1B[" + selector + ";5;" + c.code() + "m"
+}
+
+func (ansiEncoder) Begin(w io.Writer) error { return nil }
+func (ansiEncoder) End(w io.Writer) error   { return nil }
+
+func (e ansiEncoder) EncodeRow(w io.Writer, row []Cell) error {
+	var buffer strings.Builder
+	var prev_fg_col string
+	var prev_bg_col string
+	var prev_attrs Attr
+	for _, cell := range row {
+		next_fg_col := cell.FG.code()
+		next_bg_col := cell.BG.code()
+
+		// Attributes can only be switched off by clearing everything
+		if prev_attrs&^cell.Attrs != 0 || (next_bg_col == "" && prev_bg_col != "") ||
+			(next_fg_col == "" && prev_fg_col != "") {
+			buffer.WriteString(e.reset)
+			prev_bg_col = ""
+			prev_fg_col = ""
+			prev_attrs = 0
+		}
+		for i, code := range ansiAttrCodes {
+			bit := Attr(1) << uint(i)
+			if cell.Attrs&bit != 0 && prev_attrs&bit == 0 {
+				buffer.WriteString("\x1B[" + code + "m")
+			}
+		}
+		prev_attrs = cell.Attrs
+
+		if next_fg_col != "" && prev_fg_col != next_fg_col {
+			buffer.WriteString(ansiColor("38", cell.FG))
+			prev_fg_col = next_fg_col
+		}
+		if next_bg_col != "" && prev_bg_col != next_bg_col {
+			buffer.WriteString(ansiColor("48", cell.BG))
+			prev_bg_col = next_bg_col
+		}
+		buffer.WriteString(cell.Glyph)
+	}
+	buffer.WriteString(e.reset)
+	buffer.WriteString("\n")
+	_, err := io.WriteString(w, buffer.String())
+	return err
+}
+
+//
+// IRC formatting codes
+//
+
+type ircEncoder struct{}
+
+// Codes toggling each Attr, in bit order
+var ircAttrCodes = []string{"\x02", "\x1D", "\x1F", "\x16"}
+
+const ircReset = "\x0F"
+
+// Returns the code of the color, always two digits long so that digits and
+// commas in the glyph after it aren't read as part of the color
+func ircCode(c Color) string {
+	code := c.code()
+	if len(code) == 1 {
+		code = "0" + code
+	}
+	return code
+}
+
+func (ircEncoder) Begin(w io.Writer) error { return nil }
+func (ircEncoder) End(w io.Writer) error   { return nil }
+
+func (ircEncoder) EncodeRow(w io.Writer, row []Cell) error {
+	var buffer strings.Builder
+	var prev_fg_col string
+	var prev_bg_col string
+	var prev_attrs Attr
+	// Set after a comma glyph that directly follows a color code without a
+	// background, which a digit glyph next would turn into one
+	open_comma := false
+	for _, cell := range row {
+		next_fg_col := ircCode(cell.FG)
+		next_bg_col := ircCode(cell.BG)
+		wrote, bare := false, false
+
+		if (next_bg_col == "" && prev_bg_col != "") ||
+			(next_fg_col == "" && prev_fg_col != "") {
+			buffer.WriteString(ircReset)
+			prev_bg_col = ""
+			prev_fg_col = ""
+			prev_attrs = 0
+		}
+		for i, code := range ircAttrCodes {
+			if (prev_attrs^cell.Attrs)&(Attr(1)<<uint(i)) != 0 {
+				buffer.WriteString(code)
+			}
+		}
+		prev_attrs = cell.Attrs
+
+		// A background can only be given along with a foreground
+		if (next_fg_col != "" || next_bg_col != "") &&
+			(prev_fg_col != next_fg_col || prev_bg_col != next_bg_col) {
+			if next_fg_col == "" {
+				next_fg_col = "00"
+			}
+			buffer.WriteString("\x03")
+			buffer.WriteString(next_fg_col)
+			prev_fg_col = next_fg_col
+			wrote, bare = true, true
+			// A comma glyph would be read as the start of the background, so
+			// the background is given again
+			if next_bg_col != "" && (prev_bg_col != next_bg_col || strings.HasPrefix(cell.Glyph, ",")) {
+				buffer.WriteString(",")
+				buffer.WriteString(next_bg_col)
+				prev_bg_col = next_bg_col
+				bare = false
+			}
+		}
+		if open_comma && !wrote && cell.Glyph != "" && cell.Glyph[0] >= '0' && cell.Glyph[0] <= '9' {
+			// Ends the comma before the digit by repeating the color
+			buffer.WriteString("\x03")
+			buffer.WriteString(prev_fg_col)
+		}
+		open_comma = bare && strings.HasPrefix(cell.Glyph, ",")
+		buffer.WriteString(cell.Glyph)
+	}
+	buffer.WriteString(ircReset)
+	buffer.WriteString("\n")
+	_, err := io.WriteString(w, buffer.String())
+	return err
+}
+
+//
+// Plain text, colors and attributes are dropped
+//
+
+type plainEncoder struct{}
+
+func (plainEncoder) Begin(w io.Writer) error { return nil }
+func (plainEncoder) End(w io.Writer) error   { return nil }
+
+func (plainEncoder) EncodeRow(w io.Writer, row []Cell) error {
+	var buffer strings.Builder
+	for _, cell := range row {
+		buffer.WriteString(cell.Glyph)
+	}
+	buffer.WriteString("\n")
+	_, err := io.WriteString(w, buffer.String())
+	return err
+}
@@ -0,0 +1,150 @@
+package img2term
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// A character of IRC text with the colors it's shown in, -1 for none
+type ircChar struct {
+	r      rune
+	fg, bg int
+}
+
+// Reads IRC formatted text the way clients do: a color code takes up to two
+// digits, then a comma and up to two more digits only if a digit follows it
+func decodeIRC(text string) []ircChar {
+	var chars []ircChar
+	fg, bg := -1, -1
+	s := []rune(text)
+	digits := func(i int) (int, int) {
+		n, end := 0, i
+		for end < len(s) && end < i+2 && s[end] >= '0' && s[end] <= '9' {
+			n = n*10 + int(s[end]-'0')
+			end++
+		}
+		return n, end
+	}
+	for i := 0; i < len(s); {
+		switch s[i] {
+		case '\x03':
+			n, end := digits(i + 1)
+			if end == i+1 {
+				fg, bg = -1, -1
+				i++
+				continue
+			}
+			fg, i = n, end
+			if i+1 < len(s) && s[i] == ',' && s[i+1] >= '0' && s[i+1] <= '9' {
+				bg, i = digits(i + 1)
+			}
+		case '\x0F':
+			fg, bg = -1, -1
+			i++
+		case '\x02', '\x1D', '\x1F', '\x16', '\n':
+			i++
+		default:
+			chars = append(chars, ircChar{s[i], fg, bg})
+			i++
+		}
+	}
+	return chars
+}
+
+func TestIRCCodes(t *testing.T) {
+	red := Color{Opaque: true, Index: 4}
+	blue := Color{Opaque: true, Index: 12}
+	for _, test := range []struct {
+		name string
+		row  []Cell
+		want string
+	}{
+		{"one digit", []Cell{{Glyph: "▀", FG: red}}, "\x0304▀\x0F\n"},
+		{"two digits", []Cell{{Glyph: "▀", FG: blue, BG: red}}, "\x0312,04▀\x0F\n"},
+		{"digit glyph", []Cell{{Glyph: "7", FG: red, BG: red}}, "\x0304,047\x0F\n"},
+		{"comma glyph", []Cell{{Glyph: ",", FG: red}, {Glyph: "1", FG: blue}}, "\x0304,\x03121\x0F\n"},
+		{"comma then digit", []Cell{{Glyph: ",", FG: red}, {Glyph: "5", FG: red}}, "\x0304,\x03045\x0F\n"},
+		{
+			"comma then digit over a background",
+			[]Cell{{Glyph: "a", FG: red, BG: blue}, {Glyph: ",", FG: blue, BG: blue}, {Glyph: "5", FG: blue, BG: blue}},
+			"\x0304,12a\x0312,12,5\x0F\n",
+		},
+		{"background only", []Cell{{Glyph: " ", BG: red}}, "\x0300,04 \x0F\n"},
+	} {
+		var sb strings.Builder
+		if err := (ircEncoder{}).EncodeRow(&sb, test.row); err != nil {
+			t.Fatal(err)
+		}
+		got := sb.String()
+		if got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+		var want []ircChar
+		for _, cell := range test.row {
+			fg, bg := -1, -1
+			if cell.FG.Opaque {
+				fg = cell.FG.Index
+			}
+			if cell.BG.Opaque {
+				bg = cell.BG.Index
+			}
+			for _, r := range cell.Glyph {
+				want = append(want, ircChar{r, fg, bg})
+			}
+		}
+		chars := decodeIRC(got)
+		if len(chars) != len(want) {
+			t.Errorf("%s: decoded %v, want %v", test.name, chars, want)
+			continue
+		}
+		for i, c := range chars {
+			if c.r != want[i].r || c.bg != want[i].bg || (want[i].fg >= 0 && c.fg != want[i].fg) {
+				t.Errorf("%s: decoded %v, want %v", test.name, chars, want)
+				break
+			}
+		}
+	}
+}
+
+func TestFormatModes(t *testing.T) {
+	for _, test := range []struct {
+		mode   RenderMode
+		format string
+		ok     bool
+	}{
+		{Term16, "ansi", true},
+		{Term256, "irc", false},
+		{Term24bit, "irc", false},
+		{IRC, "irc", true},
+		{IRC, "ansi", false},
+		{IRC16, "ansi", false},
+		{IRC16, "plain", true},
+		{Term24bit, "plain", true},
+		{Braille, "irc", true},
+		{Sixel, "irc", false},
+	} {
+		opts := Options{Mode: test.mode, Format: test.format}
+		err := opts.validate()
+		if _, encErr := NewEncoder(opts); (encErr == nil) != (err == nil) {
+			t.Errorf("%d %s: NewEncoder got %v, validate got %v", test.mode, test.format, encErr, err)
+		}
+		if test.ok && err != nil {
+			t.Errorf("%d %s: %v", test.mode, test.format, err)
+		} else if !test.ok && !errors.Is(err, ErrFormatMode) {
+			t.Errorf("%d %s: got %v, want ErrFormatMode", test.mode, test.format, err)
+		}
+	}
+}
+
+func TestRenderInvalidMode(t *testing.T) {
+	pixels := [][]Pixel{{{alpha: 0xffff}}}
+	if Render(Term16, false, pixels) == "" {
+		t.Error("nothing rendered in 16 colors")
+	}
+	for _, mode := range []RenderMode{Braille, Sixel, Kitty, ITerm, -1, 42} {
+		if got := Render(mode, false, pixels); got != "" {
+			t.Errorf("mode %d: got %q", mode, got)
+		}
+	}
+}
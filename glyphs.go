@@ -0,0 +1,64 @@
+package img2term
+
+import "fmt"
+
+// Characters the cells of the color modes are drawn with
+type Glyphs int
+
+const (
+	GlyphsHalf     Glyphs = iota // ▀ and ▄, 1x2 pixels per cell
+	GlyphsBraille                // Braille dots in one color, 2x4 pixels per cell
+	GlyphsQuadrant               // Quadrant blocks in two colors, 2x2 pixels per cell
+	GlyphsSextant                // Sextant blocks in two colors, 2x3 pixels per cell
+	GlyphsOctant                 // Octant blocks in two colors, 2x4 pixels per cell
+	GlyphsSymbols                // Best fitting of a set of symbols in two colors, 8x16 pixels per cell
+	GlyphsShade                  // ░▒▓ mixing two of the 16 basic colors, 1x2 pixels per cell
+	GlyphsRamp                   // Characters of a ramp by gray level, 1x2 pixels per cell
+)
+
+var glyphsNames = []string{
+	GlyphsHalf:     "half",
+	GlyphsBraille:  "braille",
+	GlyphsQuadrant: "quadrant",
+	GlyphsSextant:  "sextant",
+	GlyphsOctant:   "octant",
+	GlyphsSymbols:  "symbols",
+	GlyphsShade:    "shade",
+	GlyphsRamp:     "ramp",
+}
+
+func (g Glyphs) String() string {
+	if g < 0 || int(g) >= len(glyphsNames) {
+		return fmt.Sprintf("Glyphs(%d)", int(g))
+	}
+	return glyphsNames[g]
+}
+
+// Returns the names accepted by ParseGlyphs
+func GlyphsNames() []string {
+	return append([]string(nil), glyphsNames...)
+}
+
+func ParseGlyphs(name string) (Glyphs, error) {
+	for g, n := range glyphsNames {
+		if n == name {
+			return Glyphs(g), nil
+		}
+	}
+	return GlyphsHalf, fmt.Errorf("unknown glyphs %q", name)
+}
+
+// Returns the pixels drawn in each cell with the glyphs, across and down
+func (g Glyphs) CellSize() (width, height int) {
+	switch g {
+	case GlyphsBraille, GlyphsOctant:
+		return 2, 4
+	case GlyphsQuadrant:
+		return 2, 2
+	case GlyphsSextant:
+		return 2, 3
+	case GlyphsSymbols:
+		return symbolWidth, symbolHeight
+	}
+	return 1, 2
+}
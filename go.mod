@@ -2,12 +2,11 @@ module github.com/wwared/img2term
 
 require (
 	github.com/disintegration/imaging v1.5.0
-	github.com/exrook/drawille-go v0.0.0-20180117021400-68d036fca70a
 	github.com/lucasb-eyer/go-colorful v0.0.0-20181028223441-12d3b2882a08
 	github.com/stretchr/testify v1.4.0 // indirect
 	golang.org/x/crypto v0.0.0-20181203042331-505ab145d0a9
-	golang.org/x/image v0.0.0-20181116024801-cd38e8056d9b // indirect
-	golang.org/x/sys v0.0.0-20181208175041-ad97f365e150 // indirect
+	golang.org/x/image v0.0.0-20181116024801-cd38e8056d9b
+	golang.org/x/sys v0.0.0-20181208175041-ad97f365e150
 	gopkg.in/DATA-DOG/go-sqlmock.v1 v1.3.0 // indirect
 )
 
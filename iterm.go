@@ -0,0 +1,33 @@
+package img2term
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/png"
+	"io"
+	"strconv"
+)
+
+// Sends img as a PNG with the inline image protocol of iTerm2, also understood
+// by WezTerm and others
+func renderITerm(w io.Writer, img image.Image, opts Options) error {
+	// The image is encoded again so that the preprocessing filters apply
+	var data bytes.Buffer
+	if err := png.Encode(&data, img); err != nil {
+		return err
+	}
+	cells := func(n int) string {
+		if n == 0 {
+			return "auto"
+		}
+		return strconv.Itoa(n)
+	}
+	_, err := io.WriteString(w, "\x1B]1337;File=inline=1"+
+		";size="+strconv.Itoa(data.Len())+
+		";width="+cells(opts.Columns)+
+		";height="+cells(opts.Rows)+
+		";preserveAspectRatio=1:"+
+		base64.StdEncoding.EncodeToString(data.Bytes())+"\a\n")
+	return err
+}
@@ -0,0 +1,83 @@
+package img2term
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/draw"
+	"image/png"
+	"io"
+	"strconv"
+)
+
+// Base64 bytes sent per escape sequence, the most the kitty protocol allows
+const kittyChunkSize = 4096
+
+// Transmits img with the kitty graphics protocol and places it at the cursor
+func renderKitty(w io.Writer, img image.Image, opts Options) error {
+	bounds := img.Bounds()
+	var data bytes.Buffer
+	// Transmit and display, without replies that would end up in the input
+	keys := "a=T,q=2"
+	if opts.RawRGBA {
+		rgba := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+		draw.Draw(rgba, rgba.Rect, img, bounds.Min, draw.Src)
+		data.Write(rgba.Pix)
+		keys += ",f=32,s=" + strconv.Itoa(bounds.Dx()) + ",v=" + strconv.Itoa(bounds.Dy())
+	} else {
+		if err := png.Encode(&data, img); err != nil {
+			return err
+		}
+		keys += ",f=100"
+	}
+	columns, rows := fitCells(bounds, opts)
+	if columns > 0 {
+		keys += ",c=" + strconv.Itoa(columns)
+	}
+	if rows > 0 {
+		keys += ",r=" + strconv.Itoa(rows)
+	}
+
+	payload := base64.StdEncoding.EncodeToString(data.Bytes())
+	for first := true; first || payload != ""; first = false {
+		chunk := payload
+		if len(chunk) > kittyChunkSize {
+			chunk = chunk[:kittyChunkSize]
+		}
+		payload = payload[len(chunk):]
+		more := "m=0"
+		if payload != "" {
+			more = "m=1"
+		}
+		// Only the first chunk carries the keys
+		if first {
+			more = keys + "," + more
+		}
+		if _, err := io.WriteString(w, "\x1B_G"+more+";"+chunk+"\x1B\\"); err != nil {
+			return err
+		}
+	}
+	// The cursor is left after the last column of the image
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// Returns the cells the image should be scaled to so that it fits in
+// opts.Columns by opts.Rows, leaving the terminal to work out the other side
+// from the aspect ratio. The cell size is taken from the pixel limits, or
+// assumed to be twice as tall as it is wide
+func fitCells(bounds image.Rectangle, opts Options) (columns, rows int) {
+	if opts.Columns == 0 || opts.Rows == 0 {
+		return opts.Columns, opts.Rows
+	}
+	cellWidth, cellHeight := 1.0, 2.0
+	if opts.Width > 0 && opts.Height > 0 {
+		cellWidth = float64(opts.Width) / float64(opts.Columns)
+		cellHeight = float64(opts.Height) / float64(opts.Rows)
+	}
+	// Compare the aspect ratios of the image and the cells it has to fit in
+	if float64(bounds.Dx())*float64(opts.Rows)*cellHeight >= float64(bounds.Dy())*float64(opts.Columns)*cellWidth {
+		return opts.Columns, 0
+	}
+	return 0, opts.Rows
+}
@@ -0,0 +1,263 @@
+package img2term
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"github.com/lucasb-eyer/go-colorful"
+)
+
+// Cells per channel of the approximate lookup table
+const lutSize = 64
+
+// Exact colors remembered per palette, so dithered images can't grow the
+// memo without bounds
+const maxMemo = 1 << 16
+
+// Matchers kept for reuse across renders, the least recently used ones are
+// dropped past this so rendering with many palettes can't grow without bounds
+const maxMatchers = 8
+
+// Nearest color lookups for one palette. Palette colors are converted to the
+// comparison space once, results for exact colors are memoized and the
+// approximate lookup table is filled in lazily
+type matcher struct {
+	key      string
+	palette  []colorful.Color
+	space    func(colorful.Color) lab
+	distance func(p, q lab) float64
+	points   []lab
+
+	memo     sync.Map // colorful.Color -> int
+	memoSize int32
+
+	lut       []int32 // -1 for cells that haven't been computed yet, see table
+	lutOnce   sync.Once
+	lutDirty  int32
+	cacheOnce sync.Once
+
+	spreadOnce  sync.Once
+	spreadValue float64
+}
+
+var (
+	matchersMu sync.Mutex
+	matchers   = map[string]*matcher{}
+	recentKeys []string // Keys of matchers, the most recently used last
+)
+
+func luv(c colorful.Color) lab {
+	l, u, v := c.Luv()
+	return lab{l, u, v}
+}
+
+// Returns the shared matcher for the palette of opts.Mode, with opts.Palette
+// applied, comparing colors with opts.Metric
+func matcherFor(opts Options) *matcher {
+	return sharedMatcher(opts, "", opts.palette)
+}
+
+// Same as matcherFor, for a palette made from the one of opts.Mode. kind tells
+// the palettes made from the same one apart
+func sharedMatcher(opts Options, kind string, palette func() []colorful.Color) *matcher {
+	metric := opts.Metric.resolve(opts.Mode)
+	key := fmt.Sprint(kind, opts.Mode, " ", metric)
+	if opts.Palette != nil {
+		key += fmt.Sprint(opts.Palette)
+	}
+	matchersMu.Lock()
+	defer matchersMu.Unlock()
+	for i, k := range recentKeys {
+		if k == key {
+			copy(recentKeys[i:], recentKeys[i+1:])
+			recentKeys[len(recentKeys)-1] = key
+			return matchers[key]
+		}
+	}
+	m := newMatcher(key, palette(), metric)
+	matchers[key] = m
+	recentKeys = append(recentKeys, key)
+	if len(recentKeys) > maxMatchers {
+		// Renders still using the dropped matcher keep it until they're done
+		delete(matchers, recentKeys[0])
+		recentKeys = recentKeys[1:]
+	}
+	return m
+}
+
+// Returns a matcher for any palette, compared with the given metric (which
+// can't be MetricAuto)
+func newMatcher(key string, palette []colorful.Color, metric Metric) *matcher {
+	m := &matcher{key: key, palette: palette, space: metric.space(), distance: metric.distance()}
+	m.points = make([]lab, len(m.palette))
+	for i, c := range m.palette {
+		m.points[i] = m.space(c)
+	}
+	return m
+}
+
+// Returns the lookup table, allocated the first time it's needed since most
+// matchers never use it
+func (m *matcher) table() []int32 {
+	m.lutOnce.Do(func() {
+		m.lut = make([]int32, lutSize*lutSize*lutSize)
+		for i := range m.lut {
+			m.lut[i] = -1
+		}
+	})
+	return m.lut
+}
+
+// Same as colorResolver, for the palette of m
+func (m *matcher) resolver(fast bool) func(colorful.Color) Color {
+	if fast {
+		return m.approximate
+	}
+	return m.nearest
+}
+
+// Ordered dithering amplitude for the palette, see spreadOf
+func (m *matcher) spread() float64 {
+	m.spreadOnce.Do(func() { m.spreadValue = spreadOf(m.palette) })
+	return m.spreadValue
+}
+
+func (m *matcher) color(index int) Color {
+	return Color{Opaque: true, Index: index, RGB: m.palette[index]}
+}
+
+// Same as Metric.Distance against every palette color, without converting
+// the palette colors every time
+func (m *matcher) search(c colorful.Color) int {
+	p := m.space(c)
+	last := len(m.points) - 1
+	result := last
+	dist := m.distance(p, m.points[last])
+	// start from the end so higher color indices are favored in the irc palette
+	for i := last - 1; i >= 0; i-- {
+		d := m.distance(p, m.points[i])
+		if d < dist {
+			dist = d
+			result = i
+		}
+	}
+	return result
+}
+
+func sq(v float64) float64 {
+	return v * v
+}
+
+// Returns the palette color closest to c, same as a full search
+func (m *matcher) nearest(c colorful.Color) Color {
+	if index, ok := m.memo.Load(c); ok {
+		return m.color(index.(int))
+	}
+	index := m.search(c)
+	if atomic.LoadInt32(&m.memoSize) < maxMemo {
+		atomic.AddInt32(&m.memoSize, 1)
+		m.memo.Store(c, index)
+	}
+	return m.color(index)
+}
+
+func lutCell(v float64) int {
+	i := int(v * lutSize)
+	if i < 0 {
+		return 0
+	} else if i >= lutSize {
+		return lutSize - 1
+	}
+	return i
+}
+
+// Returns the palette color closest to the center of the lookup table cell c
+// falls in. Faster than nearest, but colors close to the boundary between two
+// palette colors may get the other one
+func (m *matcher) approximate(c colorful.Color) Color {
+	r, g, b := lutCell(c.R), lutCell(c.G), lutCell(c.B)
+	cell := (r*lutSize+g)*lutSize + b
+	lut := m.table()
+	index := atomic.LoadInt32(&lut[cell])
+	if index < 0 {
+		center := colorful.Color{
+			R: (float64(r) + 0.5) / lutSize,
+			G: (float64(g) + 0.5) / lutSize,
+			B: (float64(b) + 0.5) / lutSize,
+		}
+		index = int32(m.search(center))
+		atomic.StoreInt32(&lut[cell], index)
+		atomic.StoreInt32(&m.lutDirty, 1)
+	}
+	return m.color(int(index))
+}
+
+//
+// Lookup table disk cache
+//
+
+// The file name depends on the palette colors so that changing them doesn't
+// load a stale table
+func (m *matcher) cachePath(dir string) string {
+	h := fnv.New64a()
+	fmt.Fprint(h, m.key)
+	for _, c := range m.palette {
+		binary.Write(h, binary.LittleEndian, [3]float64{c.R, c.G, c.B})
+	}
+	return filepath.Join(dir, fmt.Sprintf("lut-%d-%016x", lutSize, h.Sum64()))
+}
+
+// Fills in the lookup table from a previous run. A missing or damaged file
+// isn't an error, the table is just computed again
+func (m *matcher) loadCache(dir string) {
+	data, err := ioutil.ReadFile(m.cachePath(dir))
+	lut := m.table()
+	if err != nil || len(data) != len(lut)*2 {
+		return
+	}
+	for i := range lut {
+		index := int32(int16(binary.LittleEndian.Uint16(data[i*2:])))
+		if index >= int32(len(m.palette)) {
+			return
+		}
+		if index >= 0 && atomic.LoadInt32(&lut[i]) < 0 {
+			atomic.StoreInt32(&lut[i], index)
+		}
+	}
+}
+
+// Writes the lookup table out if new cells were computed since it was loaded
+func (m *matcher) saveCache(dir string) error {
+	if !atomic.CompareAndSwapInt32(&m.lutDirty, 1, 0) {
+		return nil
+	}
+	lut := m.table()
+	data := make([]byte, len(lut)*2)
+	for i := range lut {
+		binary.LittleEndian.PutUint16(data[i*2:], uint16(int16(atomic.LoadInt32(&lut[i]))))
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	// Write to a temporary file first so concurrent runs never see half a table
+	tmp, err := ioutil.TempFile(dir, "lut-")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), m.cachePath(dir))
+}
@@ -0,0 +1,140 @@
+package img2term
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/lucasb-eyer/go-colorful"
+)
+
+func randomColors(n int) []colorful.Color {
+	rng := rand.New(rand.NewSource(1))
+	colors := make([]colorful.Color, n)
+	for i := range colors {
+		colors[i] = colorful.Color{R: rng.Float64(), G: rng.Float64(), B: rng.Float64()}
+	}
+	return colors
+}
+
+// Nearest palette index with Metric.Distance, favoring higher indices on ties
+// like the matchers do
+func scanNearest(mode RenderMode, metric Metric, palette []colorful.Color, c colorful.Color) int {
+	result := len(palette) - 1
+	dist := metric.Distance(mode, c, palette[result])
+	for i := result - 1; i >= 0; i-- {
+		if d := metric.Distance(mode, c, palette[i]); d < dist {
+			dist, result = d, i
+		}
+	}
+	return result
+}
+
+func TestNearestMatchesFullSearch(t *testing.T) {
+	samples := randomColors(500)
+	for _, mode := range []RenderMode{Term16, Term256, IRC, IRC16} {
+		for metric := range metricNames {
+			opts := Options{Mode: mode, Metric: Metric(metric)}
+			m := newMatcher("", opts.palette(), opts.Metric.resolve(mode))
+			// Twice, the second time from the memo
+			for pass := 0; pass < 2; pass++ {
+				for _, c := range samples {
+					got := m.nearest(c).Index
+					if want := scanNearest(mode, opts.Metric, opts.palette(), c); got != want {
+						t.Fatalf("%v %v %v: got %d, want %d", mode, opts.Metric, c, got, want)
+					}
+				}
+			}
+		}
+	}
+}
+
+func benchmarkMatch(b *testing.B, match func(colorful.Color)) {
+	samples := randomColors(4096)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		match(samples[i%len(samples)])
+	}
+}
+
+func BenchmarkDistanceScan(b *testing.B) {
+	palette := colors[Term256]
+	benchmarkMatch(b, func(c colorful.Color) { scanNearest(Term256, MetricAuto, palette, c) })
+}
+
+func BenchmarkNearest(b *testing.B) {
+	m := newMatcher("", colors[Term256], MetricAuto.resolve(Term256))
+	benchmarkMatch(b, func(c colorful.Color) { m.nearest(c) })
+}
+
+func BenchmarkApproximate(b *testing.B) {
+	m := newMatcher("", colors[Term256], MetricAuto.resolve(Term256))
+	benchmarkMatch(b, func(c colorful.Color) { m.approximate(c) })
+}
+
+func TestMatchersAreBounded(t *testing.T) {
+	for i := 0; i < maxMatchers*3; i++ {
+		palette := append([]colorful.Color(nil), colors[Term16]...)
+		palette[0] = colorful.Color{R: float64(i) / 100}
+		matcherFor(Options{Mode: Term16, Palette: palette})
+	}
+	matchersMu.Lock()
+	defer matchersMu.Unlock()
+	if len(matchers) > maxMatchers || len(recentKeys) != len(matchers) {
+		t.Errorf("%d matchers kept for %d keys, at most %d expected", len(matchers), len(recentKeys), maxMatchers)
+	}
+}
+
+// The search as it was before the last palette color could be matched: the
+// scan starts from the last color, but a result that never moved was index 0
+func legacySearch(mode RenderMode, c colorful.Color) int {
+	palette := Options{Mode: mode}.palette()
+	result := 0
+	last := len(palette) - 1
+	dist := ColorDistance(mode, c, palette[last])
+	for i := last - 1; i >= 0; i-- {
+		if d := ColorDistance(mode, c, palette[i]); d < dist {
+			dist, result = d, i
+		}
+	}
+	return result
+}
+
+func TestLastPaletteColor(t *testing.T) {
+	white, black := colorful.Color{R: 1, G: 1, B: 1}, colorful.Color{}
+	for _, test := range []struct {
+		mode          RenderMode
+		c             colorful.Color
+		before, after int
+	}{
+		{Term16, white, 0, 15}, // Was drawn black
+		{Term16, black, 0, 0},
+		{Term256, white, 231, 231},
+		// 0 and 98 are both white, and ties go to the higher index like 88
+		// over 1 for black always did
+		{IRC, white, 0, 98},
+		{IRC, black, 88, 88},
+		{IRC16, white, 0, 0},
+	} {
+		m := matcherFor(Options{Mode: test.mode})
+		if got := legacySearch(test.mode, test.c); got != test.before {
+			t.Errorf("%d %v: legacy search got %d, want %d", test.mode, test.c.Hex(), got, test.before)
+		}
+		if got := m.search(test.c); got != test.after {
+			t.Errorf("%d %v: got %d, want %d", test.mode, test.c.Hex(), got, test.after)
+		}
+	}
+
+	// Nothing changes for the colors whose nearest isn't the last one
+	rng := rand.New(rand.NewSource(1))
+	for _, mode := range []RenderMode{Term16, Term256, IRC, IRC16} {
+		m := matcherFor(Options{Mode: mode})
+		last := len(m.palette) - 1
+		for i := 0; i < 2000; i++ {
+			c := colorful.Color{R: rng.Float64(), G: rng.Float64(), B: rng.Float64()}
+			before, after := legacySearch(mode, c), m.search(c)
+			if before != after && (before != 0 || after != last) {
+				t.Fatalf("%d %v: got %d, was %d", mode, c.Hex(), after, before)
+			}
+		}
+	}
+}
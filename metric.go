@@ -0,0 +1,188 @@
+package img2term
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/lucasb-eyer/go-colorful"
+)
+
+// How the distance between colors is measured when matching them to a palette
+type Metric int
+
+const (
+	MetricAuto      Metric = iota // Luv for Term16, CIE76 otherwise
+	MetricRGB                     // Euclidean distance in sRGB
+	MetricRedmean                 // sRGB weighted by the mean red, a cheap perceptual approximation
+	MetricCIE76                   // Euclidean distance in L*a*b*
+	MetricCIE94                   // CIE94 in L*a*b*, better with saturated colors
+	MetricCIEDE2000               // CIEDE2000 in L*a*b*, the most accurate and the slowest
+	MetricLuv                     // Euclidean distance in L*u*v*
+	MetricHyAB                    // Lightness difference plus a*b* distance, good for large differences
+)
+
+var metricNames = []string{
+	MetricAuto:      "auto",
+	MetricRGB:       "rgb",
+	MetricRedmean:   "redmean",
+	MetricCIE76:     "cie76",
+	MetricCIE94:     "cie94",
+	MetricCIEDE2000: "ciede2000",
+	MetricLuv:       "luv",
+	MetricHyAB:      "hyab",
+}
+
+func (m Metric) String() string {
+	if m < 0 || int(m) >= len(metricNames) {
+		return fmt.Sprintf("Metric(%d)", int(m))
+	}
+	return metricNames[m]
+}
+
+// Returns the names accepted by ParseMetric
+func MetricNames() []string {
+	return append([]string(nil), metricNames...)
+}
+
+func ParseMetric(name string) (Metric, error) {
+	for m, n := range metricNames {
+		if n == name {
+			return Metric(m), nil
+		}
+	}
+	return MetricAuto, fmt.Errorf("unknown color metric %q", name)
+}
+
+// Returns the metric MetricAuto stands for in mode
+func (m Metric) resolve(mode RenderMode) Metric {
+	if m != MetricAuto {
+		return m
+	}
+	if mode == Term16 { // heuristic; I think it looks nicer
+		return MetricLuv
+	}
+	return MetricCIE76
+}
+
+// Converts colors to the space the metric compares them in
+func (m Metric) space() func(colorful.Color) lab {
+	switch m {
+	case MetricRGB, MetricRedmean:
+		return func(c colorful.Color) lab { return lab{c.R, c.G, c.B} }
+	case MetricLuv:
+		return luv
+	default:
+		return toLab
+	}
+}
+
+// Returns the distance between colors converted with space. The first one
+// is the reference for the metrics that aren't symmetric
+func (m Metric) distance() func(p, q lab) float64 {
+	switch m {
+	case MetricRedmean:
+		return redmean
+	case MetricCIE94:
+		return cie94
+	case MetricCIEDE2000:
+		return ciede2000
+	case MetricHyAB:
+		return func(p, q lab) float64 {
+			return math.Abs(p.l-q.l) + math.Sqrt(sq(p.a-q.a)+sq(p.b-q.b))
+		}
+	default:
+		return func(p, q lab) float64 {
+			return math.Sqrt(sq(p.l-q.l) + sq(p.a-q.a) + sq(p.b-q.b))
+		}
+	}
+}
+
+// Returns the distance between c1 and c2 as measured by the metric, resolving
+// MetricAuto for mode
+func (m Metric) Distance(mode RenderMode, c1, c2 colorful.Color) float64 {
+	m = m.resolve(mode)
+	space := m.space()
+	return m.distance()(space(c1), space(c2))
+}
+
+// See https://www.compuphase.com/cmetric.htm, with channels from 0 to 1
+func redmean(p, q lab) float64 {
+	r := (p.l + q.l) / 2
+	return math.Sqrt((2+r)*sq(p.l-q.l) + 4*sq(p.a-q.a) + (3-r)*sq(p.b-q.b))
+}
+
+// The formulas expect L*a*b* values 100 times larger than go-colorful's, so
+// they are scaled up and the distance back down, like go-colorful does
+func cie94(p, q lab) float64 {
+	l1, a1, b1 := p.l*100, p.a*100, p.b*100
+	l2, a2, b2 := q.l*100, q.a*100, q.b*100
+	c1 := math.Sqrt(sq(a1) + sq(b1))
+	c2 := math.Sqrt(sq(a2) + sq(b2))
+	deltaC := c1 - c2
+	deltaH2 := sq(a1-a2) + sq(b1-b2) - sq(deltaC)
+	sc := 1 + 0.045*c1
+	sh := 1 + 0.015*c1
+	return math.Sqrt(sq(l1-l2)+sq(deltaC/sc)+deltaH2/sq(sh)) * 0.01
+}
+
+func ciede2000(p, q lab) float64 {
+	l1, a1, b1 := p.l*100, p.a*100, p.b*100
+	l2, a2, b2 := q.l*100, q.a*100, q.b*100
+
+	cmean := (math.Sqrt(sq(a1)+sq(b1)) + math.Sqrt(sq(a2)+sq(b2))) / 2
+	cmean7 := math.Pow(cmean, 7)
+	g := 0.5 * (1 - math.Sqrt(cmean7/(cmean7+math.Pow(25, 7))))
+	ap1, ap2 := (1+g)*a1, (1+g)*a2
+	cp1, cp2 := math.Sqrt(sq(ap1)+sq(b1)), math.Sqrt(sq(ap2)+sq(b2))
+	hue := func(b, ap float64) float64 {
+		if b == 0 && ap == 0 {
+			return 0
+		}
+		h := math.Atan2(b, ap) * 180 / math.Pi
+		if h < 0 {
+			h += 360
+		}
+		return h
+	}
+	hp1, hp2 := hue(b1, ap1), hue(b2, ap2)
+
+	deltaL := l2 - l1
+	deltaC := cp2 - cp1
+	dh := 0.0
+	product := cp1 * cp2
+	if product != 0 {
+		dh = hp2 - hp1
+		if dh > 180 {
+			dh -= 360
+		} else if dh < -180 {
+			dh += 360
+		}
+	}
+	deltaH := 2 * math.Sqrt(product) * math.Sin(dh/2*math.Pi/180)
+
+	lmean := (l1 + l2) / 2
+	cpmean := (cp1 + cp2) / 2
+	hmean := hp1 + hp2
+	if product != 0 {
+		hmean /= 2
+		if math.Abs(hp1-hp2) > 180 {
+			if hp1+hp2 < 360 {
+				hmean += 180
+			} else {
+				hmean -= 180
+			}
+		}
+	}
+	rad := math.Pi / 180
+	t := 1 - 0.17*math.Cos((hmean-30)*rad) + 0.24*math.Cos(2*hmean*rad) +
+		0.32*math.Cos((3*hmean+6)*rad) - 0.2*math.Cos((4*hmean-63)*rad)
+	theta := 30 * math.Exp(-sq((hmean-275)/25))
+	cpmean7 := math.Pow(cpmean, 7)
+	rc := 2 * math.Sqrt(cpmean7/(cpmean7+math.Pow(25, 7)))
+	sl := 1 + 0.015*sq(lmean-50)/math.Sqrt(20+sq(lmean-50))
+	sc := 1 + 0.045*cpmean
+	sh := 1 + 0.015*cpmean*t
+	rt := -math.Sin(2*theta*rad) * rc
+
+	return math.Sqrt(sq(deltaL/sl)+sq(deltaC/sc)+sq(deltaH/sh)+rt*(deltaC/sc)*(deltaH/sh)) * 0.01
+}
@@ -0,0 +1,240 @@
+package img2term
+
+import (
+	"math"
+
+	"github.com/lucasb-eyer/go-colorful"
+)
+
+// Quadrant glyphs by the pixels they cover, bit 0 being the top left one,
+// bit 1 the top right, bit 2 the bottom left and bit 3 the bottom right
+var quadrantGlyphs = []string{
+	" ", "▘", "▝", "▀", "▖", "▌", "▞", "▛", "▗", "▚", "▐", "▜", "▄", "▙", "▟", "█",
+}
+
+func quadrantGlyph(mask uint) string {
+	return quadrantGlyphs[mask]
+}
+
+// Sextant glyphs cover 2x3 pixels, bits numbered the same way. The sextant
+// block (U+1FB00) leaves out the patterns that already had a character
+func sextantGlyph(mask uint) string {
+	switch mask {
+	case 0:
+		return " "
+	case 0x15:
+		return "▌"
+	case 0x2a:
+		return "▐"
+	case 0x3f:
+		return "█"
+	}
+	r := 0x1fb00 + rune(mask) - 1
+	if mask > 0x15 {
+		r--
+	}
+	if mask > 0x2a {
+		r--
+	}
+	return string(r)
+}
+
+// Octant patterns (2x4 pixels) that were encoded before the octants were, and
+// are left out of the octant block
+var octantExisting = map[uint]string{
+	0x00: " ", 0xff: "█",
+	0x0f: "▀", 0xf0: "▄", 0x55: "▌", 0xaa: "▐",
+	0x05: "▘", 0x0a: "▝", 0x50: "▖", 0xa0: "▗", 0xa5: "▚", 0x5a: "▞",
+	0x5f: "▛", 0xaf: "▜", 0xf5: "▙", 0xfa: "▟",
+	0x03: "\U0001fb82", 0xc0: "▂", 0x3f: "\U0001fb85", 0xfc: "▆",
+	0x01: "\U0001cea8", 0x02: "\U0001ceab", 0x40: "\U0001cea3", 0x80: "\U0001cea0",
+	0x14: "\U0001fbe6", 0x28: "\U0001fbe7",
+}
+
+// Octant glyphs by pattern, the octant block (U+1CD00) has the remaining ones
+// in increasing order of their bits
+var octantGlyphs = func() []string {
+	glyphs := make([]string, 256)
+	r := rune(0x1cd00)
+	for mask := range glyphs {
+		if g, ok := octantExisting[uint(mask)]; ok {
+			glyphs[mask] = g
+		} else {
+			glyphs[mask] = string(r)
+			r++
+		}
+	}
+	return glyphs
+}()
+
+func octantGlyph(mask uint) string {
+	return octantGlyphs[mask]
+}
+
+// Two color block layout, every cell showing a pattern of width by height
+// pixels in its foreground color over its background. Pixels are resolved to
+// the palette like in the half block layout, then the pair of colors that
+// splits each cell with the least error by opts.Metric is picked. glyph returns
+// the glyph covering the pixels set in mask, counted across and then down
+func layoutMosaic(rows pixelRows, opts Options, emit func([][]Cell) error, width, height int, glyph func(mask uint) string) error {
+	metric := opts.Metric.resolve(opts.Mode)
+	space, distance := metric.space(), metric.distance()
+	jobs := opts.jobs()
+	band := jobs * bandRowsPerJob * height
+	colors := make([][]Color, band)
+	for i := range colors {
+		colors[i] = make([]Color, rows.width)
+	}
+	columns := (rows.width + width - 1) / width
+	cells := make([][]Cell, band/height)
+	for i := range cells {
+		cells[i] = make([]Cell, columns)
+	}
+	resolver := newResolver(rows.width, opts)
+	for first := 0; first < rows.height; first += band {
+		n := rows.height - first
+		if n > band {
+			n = band
+		}
+		resolver.resolve(rows, first, colors[:n], jobs)
+		count := (n + height - 1) / height
+		parallelFor(count, jobs, func(i int) {
+			block := make([]Color, width*height)
+			for cx := range cells[i] {
+				// Pixels past the edges of the image are transparent
+				for dy := 0; dy < height; dy++ {
+					for dx := 0; dx < width; dx++ {
+						y, x := i*height+dy, cx*width+dx
+						block[dy*width+dx] = Color{}
+						if y < n && x < rows.width {
+							block[dy*width+dx] = colors[y][x]
+						}
+					}
+				}
+				cells[i][cx] = mosaicCell(block, glyph, space, distance)
+			}
+		})
+		if err := emit(cells[:count]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Picks the two colors and the pattern that show the block of pixels best.
+// Transparent pixels can only be left to the background, so blocks that have
+// any get a single foreground color. Colors are compared with distance after
+// converting them with space
+func mosaicCell(block []Color, glyph func(mask uint) string, space func(colorful.Color) lab, distance func(p, q lab) float64) Cell {
+	var distinct []Color
+	transparent := false
+	for _, c := range block {
+		if !c.Opaque {
+			transparent = true
+			continue
+		}
+		if !containsColor(distinct, c) {
+			distinct = append(distinct, c)
+		}
+	}
+	full := uint(1)<<uint(len(block)) - 1
+	if len(distinct) == 0 {
+		return Cell{Glyph: glyph(0)}
+	}
+	points := make([]lab, len(block))
+	for i, c := range block {
+		points[i] = space(c.RGB)
+	}
+
+	if transparent || len(distinct) == 1 {
+		var mask uint
+		for i, c := range block {
+			if c.Opaque {
+				mask |= 1 << uint(i)
+			}
+		}
+		return Cell{Glyph: glyph(mask), FG: blend(medoid(block, points, mask, distance), block, mask)}
+	}
+
+	// Every pixel goes to the closest of each pair of colors the block has
+	bestMask, bestError := full, math.Inf(1)
+	var fg, bg Color
+	for i := range distinct {
+		for j := i + 1; j < len(distinct); j++ {
+			p, q := space(distinct[i].RGB), space(distinct[j].RGB)
+			var mask uint
+			total := 0.0
+			for k, point := range points {
+				dp, dq := distance(point, p), distance(point, q)
+				if dp <= dq {
+					mask |= 1 << uint(k)
+					total += dp
+				} else {
+					total += dq
+				}
+			}
+			if total < bestError {
+				bestMask, bestError = mask, total
+				fg, bg = distinct[i], distinct[j]
+			}
+		}
+	}
+	// Keep the first pixel in the foreground, so the same patterns always
+	// use the same glyphs
+	if bestMask&1 == 0 {
+		bestMask, fg, bg = full&^bestMask, bg, fg
+	}
+	return Cell{
+		Glyph: glyph(bestMask),
+		FG:    blend(fg, block, bestMask),
+		BG:    blend(bg, block, full&^bestMask),
+	}
+}
+
+func containsColor(colors []Color, c Color) bool {
+	for _, other := range colors {
+		if other == c {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the color of the pixels in mask that is closest to all of them
+func medoid(block []Color, points []lab, mask uint, distance func(p, q lab) float64) Color {
+	var best Color
+	bestTotal := math.Inf(1)
+	for i, c := range block {
+		if mask&(1<<uint(i)) == 0 {
+			continue
+		}
+		total := 0.0
+		for j, point := range points {
+			if mask&(1<<uint(j)) != 0 {
+				total += distance(point, points[i])
+			}
+		}
+		if total < bestTotal {
+			best, bestTotal = c, total
+		}
+	}
+	return best
+}
+
+// Direct colors aren't limited to the ones in the block, so they are replaced
+// by the average of the pixels in mask. Palette colors are kept
+func blend(c Color, block []Color, mask uint) Color {
+	if c.Index >= 0 {
+		return c
+	}
+	var sum colorful.Color
+	n := 0
+	for i, px := range block {
+		if mask&(1<<uint(i)) != 0 {
+			sum.R, sum.G, sum.B = sum.R+px.RGB.R, sum.G+px.RGB.G, sum.B+px.RGB.B
+			n++
+		}
+	}
+	c.RGB = average(sum, n)
+	return c
+}
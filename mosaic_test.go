@@ -0,0 +1,33 @@
+package img2term
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestMosaicMetric(t *testing.T) {
+	// The pair of colors that splits this block best depends on the metric
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	img.Set(0, 0, color.RGBA{0x40, 0x80, 0x40, 0xff})
+	img.Set(1, 0, color.RGBA{0xbf, 0xff, 0xbf, 0xff})
+	img.Set(0, 1, color.RGBA{0xff, 0x80, 0xbf, 0xff})
+	img.Set(1, 1, color.RGBA{0xbf, 0xff, 0x80, 0xff})
+	for _, test := range []struct {
+		metric Metric
+		want   string
+	}{
+		{MetricAuto, "▜"},
+		{MetricCIE76, "▜"},
+		{MetricRGB, "▘"},
+	} {
+		opts := Options{Mode: Term24bit, Glyphs: GlyphsQuadrant, Metric: test.metric}
+		grid, err := Layout(img, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := grid[0][0].Glyph; got != test.want {
+			t.Errorf("metric %v: got %q, want %q", test.metric, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package img2term
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// Pixel rows processed at once per job. Bigger bands mean less waiting at the
+// end of each band but more memory
+const bandRowsPerJob = 8
+
+func (opts Options) jobs() int {
+	if opts.Jobs > 0 {
+		return opts.Jobs
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+// Calls process for every i in [0, n) on up to jobs goroutines. Indices are
+// handed out in increasing order, which the wavefront relies on
+func parallelFor(n, jobs int, process func(i int)) {
+	if jobs <= 1 || n <= 1 {
+		for i := 0; i < n; i++ {
+			process(i)
+		}
+		return
+	}
+	if jobs > n {
+		jobs = n
+	}
+	var next int32 = -1
+	var wg sync.WaitGroup
+	wg.Add(jobs)
+	for j := 0; j < jobs; j++ {
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(atomic.AddInt32(&next, 1))
+				if i >= n {
+					return
+				}
+				process(i)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// Lets rows that depend on the row above be processed concurrently, with each
+// row trailing behind the previous one. A pixel may depend on pixels of the
+// previous row up to lag columns to its right
+type wavefront struct {
+	progress []int32 // Pixels finished in each row
+	width    int
+	lag      int
+}
+
+func newWavefront(rows, width, lag int) *wavefront {
+	return &wavefront{progress: make([]int32, rows), width: width, lag: lag}
+}
+
+// Blocks until every pixel of the previous row that pixel x of row i depends
+// on is finished
+func (wf *wavefront) wait(i, x int) {
+	if wf == nil || i == 0 {
+		return
+	}
+	need := x + wf.lag + 1
+	if need > wf.width {
+		need = wf.width
+	}
+	for int(atomic.LoadInt32(&wf.progress[i-1])) < need {
+		runtime.Gosched()
+	}
+}
+
+func (wf *wavefront) done(i, x int) {
+	if wf != nil {
+		atomic.StoreInt32(&wf.progress[i], int32(x+1))
+	}
+}
+
+// Rightmost column of the previous rows a kernel pulls error from
+func kernelLag(kernel []diffusion) int {
+	lag := 0
+	for _, d := range kernel {
+		if d.dy > 0 && -d.dx > lag {
+			lag = -d.dx
+		}
+	}
+	return lag
+}
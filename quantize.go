@@ -0,0 +1,116 @@
+package img2term
+
+import (
+	"sort"
+
+	"github.com/lucasb-eyer/go-colorful"
+)
+
+// A color of the image and how many pixels have it
+type colorCount struct {
+	rgb [3]uint8
+	n   int
+}
+
+// Counts the opaque colors of the pixels, with 8 bits per channel
+func histogram(pixels [][]Pixel) []colorCount {
+	counts := map[[3]uint8]int{}
+	for _, row := range pixels {
+		for _, px := range row {
+			if px.alpha < TRANSPARENCY_THRESHOLD {
+				continue
+			}
+			r, g, b := px.color.Clamped().RGB255()
+			counts[[3]uint8{r, g, b}]++
+		}
+	}
+	hist := make([]colorCount, 0, len(counts))
+	for rgb, n := range counts {
+		hist = append(hist, colorCount{rgb, n})
+	}
+	// Sorted so that the palette doesn't depend on the map order
+	sort.Slice(hist, func(i, j int) bool {
+		a, b := hist[i].rgb, hist[j].rgb
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		} else if a[1] != b[1] {
+			return a[1] < b[1]
+		}
+		return a[2] < b[2]
+	})
+	return hist
+}
+
+// Picks up to size colors representing hist with the median cut algorithm.
+// The box with the widest channel is split at its median pixel until there
+// are enough boxes, and each box becomes the average of its colors
+func medianCut(hist []colorCount, size int) []colorful.Color {
+	if len(hist) == 0 {
+		return nil
+	}
+	boxes := [][]colorCount{hist}
+	for len(boxes) < size {
+		best, bestChannel, bestRange := -1, 0, 0
+		for i, box := range boxes {
+			if len(box) < 2 {
+				continue
+			}
+			if channel, spread := widestChannel(box); spread > bestRange {
+				best, bestChannel, bestRange = i, channel, spread
+			}
+		}
+		if best < 0 {
+			break
+		}
+		box := boxes[best]
+		sort.SliceStable(box, func(i, j int) bool {
+			return box[i].rgb[bestChannel] < box[j].rgb[bestChannel]
+		})
+		total := 0
+		for _, c := range box {
+			total += c.n
+		}
+		// Split after the median pixel, keeping at least one color per half
+		split, seen := 1, 0
+		for i, c := range box[:len(box)-1] {
+			seen += c.n
+			split = i + 1
+			if seen*2 >= total {
+				break
+			}
+		}
+		boxes[best] = box[:split]
+		boxes = append(boxes, box[split:])
+	}
+
+	palette := make([]colorful.Color, len(boxes))
+	for i, box := range boxes {
+		var r, g, b, n float64
+		for _, c := range box {
+			weight := float64(c.n)
+			r += float64(c.rgb[0]) * weight
+			g += float64(c.rgb[1]) * weight
+			b += float64(c.rgb[2]) * weight
+			n += weight
+		}
+		palette[i] = colorful.Color{R: r / n / 255.0, G: g / n / 255.0, B: b / n / 255.0}
+	}
+	return palette
+}
+
+func widestChannel(box []colorCount) (channel, spread int) {
+	for ch := 0; ch < 3; ch++ {
+		low, high := box[0].rgb[ch], box[0].rgb[ch]
+		for _, c := range box[1:] {
+			if c.rgb[ch] < low {
+				low = c.rgb[ch]
+			} else if c.rgb[ch] > high {
+				high = c.rgb[ch]
+			}
+		}
+		if int(high-low) > spread {
+			channel, spread = ch, int(high-low)
+		}
+	}
+	return channel, spread
+}
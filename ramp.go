@@ -0,0 +1,62 @@
+package img2term
+
+// Characters GlyphsRamp uses when Options.Ramp is empty, from the lightest to
+// the densest
+const DefaultRamp = " .:-=+*#%@"
+
+// Character ramp layout. Every cell shows the average of the two pixels it
+// covers as the character of the ramp for its gray level, drawn in its color.
+// The plain format leaves the colors out, for text that has to stay readable
+// without escape sequences
+func layoutRamp(rows pixelRows, opts Options, emit func([][]Cell) error) error {
+	ramp := []rune(opts.Ramp)
+	if len(ramp) == 0 {
+		ramp = []rune(DefaultRamp)
+	}
+	pairs := pairRows(rows)
+	jobs := opts.jobs()
+	band := jobs * bandRowsPerJob
+	pixels := make([][]Pixel, band)
+	colors := make([][]Color, band)
+	cells := make([][]Cell, band)
+	for i := range pixels {
+		pixels[i] = make([]Pixel, rows.width)
+		colors[i] = make([]Color, rows.width)
+		cells[i] = make([]Cell, rows.width)
+	}
+	resolver := newResolver(rows.width, opts)
+	for first := 0; first < pairs.height; first += band {
+		n := pairs.height - first
+		if n > band {
+			n = band
+		}
+		// The pixels are kept for their gray levels, the resolver gets them
+		// from the copy
+		parallelFor(n, jobs, func(i int) {
+			pairs.read(first+i, pixels[i])
+		})
+		kept := pixelRows{width: rows.width, height: pairs.height, read: func(y int, row []Pixel) {
+			copy(row, pixels[y-first])
+		}}
+		resolver.resolve(kept, first, colors[:n], jobs)
+		parallelFor(n, jobs, func(i int) {
+			for x, px := range pixels[i] {
+				cell := Cell{Glyph: " "}
+				if px.alpha >= TRANSPARENCY_THRESHOLD {
+					level := int(luminance(px.color) * float64(len(ramp)))
+					if level >= len(ramp) {
+						level = len(ramp) - 1
+					} else if level < 0 {
+						level = 0
+					}
+					cell.Glyph, cell.FG = string(ramp[level]), colors[i][x]
+				}
+				cells[i][x] = cell
+			}
+		})
+		if err := emit(cells[:n]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
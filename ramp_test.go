@@ -0,0 +1,44 @@
+package img2term
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestRampDigitsInIRC(t *testing.T) {
+	// Red is drawn with a comma and the lighter red with a digit, both in the
+	// same color, so the comma is followed by a digit without a code between
+	img := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for y := 0; y < 2; y++ {
+		img.Set(0, y, color.RGBA{0xff, 0, 0, 0xff})
+		img.Set(1, y, color.RGBA{0xff, 0x10, 0x10, 0xff})
+		img.Set(2, y, color.RGBA{0xff, 0, 0, 0xff})
+		img.Set(3, y, color.RGBA{0xff, 0x10, 0x10, 0xff})
+	}
+	opts := Options{Mode: IRC, Glyphs: GlyphsRamp, Ramp: ",5#"}
+	grid, err := Layout(img, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []ircChar
+	for _, cell := range grid[0] {
+		want = append(want, ircChar{[]rune(cell.Glyph)[0], cell.FG.Index, -1})
+	}
+	if string([]rune{want[0].r, want[1].r, want[2].r, want[3].r}) != ",5,5" || want[0].fg != want[1].fg {
+		t.Fatalf("the cells should be ,5,5 in one color, got %v", want)
+	}
+	text, err := RenderToText(img, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := decodeIRC(text)
+	if len(got) != len(want) {
+		t.Fatalf("decoded %v from %q, want %v", got, text, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("decoded %v from %q, want %v", got, text, want)
+		}
+	}
+}
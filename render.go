@@ -1,15 +1,16 @@
-package main
+// Package img2term renders images as text using terminal or IRC color codes.
+package img2term
 
 import (
-	"bytes"
+	"bufio"
+	"errors"
 	"fmt"
 	"image"
 	"image/color"
-	"strconv"
+	"io"
 	"strings"
 
 	"github.com/disintegration/imaging"
-	"github.com/exrook/drawille-go"
 	"github.com/lucasb-eyer/go-colorful"
 )
 
@@ -18,17 +19,153 @@ type Pixel struct {
 	alpha uint32
 }
 
+// Rendering options
+type Options struct {
+	Mode          RenderMode
+	Grayscale     bool             // Make the image grayscale (always done for Braille)
+	Invert        bool             // Invert the image colors
+	Autocrop      bool             // Crop out same-color or transparent borders
+	UseSpaces     bool             // Use 2 spaces per pixel instead of fitting two pixels in ▀
+	Width         int              // Downscale image if wider than Width pixels (0 means no limit)
+	Height        int              // Downscale image if taller than Height pixels (0 means no limit)
+	Format        string           // Name of a registered Encoder, empty for the default of Mode
+	Dither        Dither           // Dithering for the palette-limited modes (ignored by braille dots and symbols)
+	Metric        Metric           // How colors are matched to the palette
+	Glyphs        Glyphs           // Characters the cells are drawn with (Braille only takes GlyphsHalf, drawing plain dots)
+	DotBackground bool             // Color the unlit dots of GlyphsBraille cells too
+	Dots          Dots             // How braille dots are lit from the gray level
+	DotThreshold  *float64         // Gray level from 0 to 1 braille dots are lit above, nil for 0.5
+	DotGamma      float64          // Gray levels are raised to 1/DotGamma before lighting dots, 0 for 1
+	Symbols       Symbols          // Characters GlyphsSymbols picks from, 0 for SymbolsDefault
+	Ramp          string           // Characters of GlyphsRamp from the lightest to the densest, empty for DefaultRamp
+	FastMatch     bool             // Match colors with a lookup table, faster but approximate
+	CacheDir      string           // Directory to keep FastMatch lookup tables in across runs, empty to disable
+	Jobs          int              // Rows rendered concurrently, 0 or less for GOMAXPROCS
+	Columns       int              // Cells the image is scaled to fit by the kitty and iterm modes, 0 for its size in pixels
+	Rows          int              // Same as Columns, for the height
+	RawRGBA       bool             // Send uncompressed pixels instead of PNG in the kitty mode
+	Terminfo      *Terminfo        // Description of the terminal, nil to assume xterm
+	Palette       []colorful.Color // The 16 basic colors in ANSI order, nil for the defaults of Mode (see LoadPalette)
+}
+
+// Error kinds reported by the rendering functions, check for them with errors.Is
+var (
+	ErrInvalidMode = errors.New("invalid render mode")
+	ErrInvalidSize = errors.New("invalid output size")
+	ErrNoCells     = errors.New("render mode draws pixels, not cells")
+	ErrPalette     = errors.New("palette must have 16 colors")
+)
+
+// Modes that draw the image with terminal graphics instead of laying it out
+// in cells
+var graphicsModes = map[RenderMode]func(w io.Writer, img image.Image, opts Options) error{
+	Sixel: renderSixel,
+	Kitty: renderKitty,
+	ITerm: renderITerm,
+}
+
+func (opts Options) validate() error {
+	if opts.Mode < Term16 || opts.Mode > ITerm {
+		return fmt.Errorf("%w: %d", ErrInvalidMode, opts.Mode)
+	}
+	if opts.Width < 0 || opts.Height < 0 {
+		return fmt.Errorf("%w: %dx%d", ErrInvalidSize, opts.Width, opts.Height)
+	}
+	if opts.Metric < MetricAuto || opts.Metric > MetricHyAB {
+		return fmt.Errorf("invalid color metric: %d", opts.Metric)
+	}
+	if opts.Glyphs < GlyphsHalf || int(opts.Glyphs) >= len(glyphsNames) {
+		return fmt.Errorf("invalid glyphs: %d", opts.Glyphs)
+	}
+	if opts.Glyphs != GlyphsHalf && opts.Mode == Braille {
+		return fmt.Errorf("%w: braille draws plain dots, not %v glyphs", ErrInvalidMode, opts.Glyphs)
+	}
+	if opts.Glyphs == GlyphsShade && opts.Mode != Term16 && opts.Mode != IRC16 {
+		return fmt.Errorf("%w: shade glyphs need 16 colors, not %d", ErrInvalidMode, opts.Mode)
+	}
+	if opts.Dots < DotsDiffuse || opts.Dots > DotsAdaptive {
+		return fmt.Errorf("invalid braille dots method: %d", opts.Dots)
+	}
+	if t := opts.DotThreshold; t != nil && (*t < 0 || *t > 1) {
+		return fmt.Errorf("invalid braille dots threshold: %v", *t)
+	}
+	if opts.DotGamma < 0 {
+		return fmt.Errorf("invalid braille dots gamma: %v", opts.DotGamma)
+	}
+	if opts.Symbols>>uint(len(symbolsNames)) != 0 {
+		return fmt.Errorf("invalid symbols: %d", opts.Symbols)
+	}
+	if err := checkFormat(opts); err != nil {
+		return err
+	}
+	if opts.Palette != nil && len(opts.Palette) != 16 {
+		return fmt.Errorf("%w, not %d", ErrPalette, len(opts.Palette))
+	}
+	if opts.Columns < 0 || opts.Rows < 0 {
+		return fmt.Errorf("%w: %dx%d cells", ErrInvalidSize, opts.Columns, opts.Rows)
+	}
+	return nil
+}
+
 // Rendering entrypoint
-func RenderToText(img image.Image, grayscale bool, invert bool, autocrop bool, use_spaces bool, width int, height int, mode RenderMode) string {
-	if grayscale || mode == braille {
+func RenderToText(img image.Image, opts Options) (string, error) {
+	var sb strings.Builder
+	if err := RenderTo(&sb, img, opts); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+// Renders img to w one row at a time, without keeping the whole output or a
+// full copy of the pixels in memory
+func RenderTo(w io.Writer, img image.Image, opts Options) error {
+	if err := opts.validate(); err != nil {
+		return err
+	}
+	if render, ok := graphicsModes[opts.Mode]; ok {
+		buffer := bufio.NewWriter(w)
+		if err := render(buffer, Preprocess(img, opts), opts); err != nil {
+			return err
+		}
+		return buffer.Flush()
+	}
+	enc, err := NewEncoder(opts)
+	if err != nil {
+		return err
+	}
+	buffer := bufio.NewWriter(w)
+	if err := enc.Begin(buffer); err != nil {
+		return err
+	}
+	err = layout(Preprocess(img, opts), opts, func(rows [][]Cell) error {
+		if err := encodeRows(buffer, enc, rows, opts.jobs()); err != nil {
+			return err
+		}
+		return buffer.Flush()
+	})
+	if err != nil {
+		return err
+	}
+	if err := enc.End(buffer); err != nil {
+		return err
+	}
+	return buffer.Flush()
+}
+
+// Applies the filters and resizing from opts to img
+func Preprocess(img image.Image, opts Options) image.Image {
+	if opts.Mode == Braille {
+		img = grayscaleDots(img)
+	} else if opts.Grayscale {
 		img = Grayscale(img)
 	}
-	if invert {
+	if opts.Invert {
 		img = imaging.Invert(img)
 	}
-	if autocrop {
+	if opts.Autocrop {
 		img = CropBorders(img)
 	}
+	width, height := opts.Width, opts.Height
 	if width != 0 || height != 0 {
 		if height != 0 && img.Bounds().Size().Y > height {
 			img = imaging.Resize(img, 0, height, imaging.NearestNeighbor)
@@ -37,10 +174,7 @@ func RenderToText(img image.Image, grayscale bool, invert bool, autocrop bool, u
 			img = imaging.Resize(img, width, 0, imaging.NearestNeighbor)
 		}
 	}
-	if mode == braille {
-		return RenderBraille(GetPixels(img))
-	}
-	return Render(mode, use_spaces, GetPixels(img))
+	return img
 }
 
 //
@@ -62,8 +196,31 @@ func Grayscale(img image.Image) image.Image {
 	return gray
 }
 
-// PSA this code is repetitive and ugly
+// Same as Grayscale, but transparent pixels stay transparent so that braille
+// dots aren't lit for them
+func grayscaleDots(img image.Image) image.Image {
+	bounds := img.Bounds()
+	gray := image.NewNRGBA64(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := img.At(x, y)
+			if IsTransparent(c) {
+				continue
+			}
+			v := color.Gray16Model.Convert(c).(color.Gray16).Y
+			gray.SetNRGBA64(x, y, color.NRGBA64{R: v, G: v, B: v, A: 0xFFFF})
+		}
+	}
+	return gray
+}
+
 func CropBorders(img image.Image) image.Image {
+	return cropTo(img, borderBounds(img))
+}
+
+// Returns the part of img inside its same-color or transparent borders
+// PSA this code is repetitive and ugly
+func borderBounds(img image.Image) image.Rectangle {
 	bounds := img.Bounds()
 	ix, iy, w, h := bounds.Min.X, bounds.Min.Y, bounds.Max.X, bounds.Max.Y
 	background := img.At(ix, iy)
@@ -131,6 +288,12 @@ func CropBorders(img image.Image) image.Image {
 			break
 		}
 	}
+	return image.Rect(ix, iy, w, h)
+}
+
+func cropTo(img image.Image, rect image.Rectangle) image.Image {
+	bounds := img.Bounds()
+	ix, iy, w, h := rect.Min.X, rect.Min.Y, rect.Max.X, rect.Max.Y
 	if ix == bounds.Min.X && iy == bounds.Min.Y && w == bounds.Max.X && h == bounds.Max.Y {
 		return img
 	}
@@ -149,219 +312,155 @@ func CropBorders(img image.Image) image.Image {
 
 // Extracts Pixels from an image.Image
 func GetPixels(img image.Image) [][]Pixel {
-	bounds := img.Bounds()
-	img_size := bounds.Size()
-	w, h := img_size.X, img_size.Y
-	pixels := make([][]Pixel, h)
-	for i := 0; i < h; i++ {
-		pixels[i] = make([]Pixel, w)
+	rows := imageRows(img)
+	pixels := make([][]Pixel, rows.height)
+	for y := range pixels {
+		pixels[y] = make([]Pixel, rows.width)
+		rows.read(y, pixels[y])
 	}
+	return pixels
+}
 
-	di := 0
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		dj := 0
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			orig_color := img.At(x, y)
-			color, alpha_flag := colorful.MakeColor(orig_color)
-			if !alpha_flag {
-				color = colorful.Color{R: 1.0, G: 1.0, B: 1.0}
-			}
-			_, _, _, alpha := orig_color.RGBA()
-			pixels[di][dj] = Pixel{
-				color: color,
-				alpha: alpha,
-			}
-			dj++
-		}
-		di++
+func MakePixel(orig_color color.Color) Pixel {
+	color, alpha_flag := colorful.MakeColor(orig_color)
+	if !alpha_flag {
+		color = colorful.Color{R: 1.0, G: 1.0, B: 1.0}
 	}
+	_, _, _, alpha := orig_color.RGBA()
+	return Pixel{
+		color: color,
+		alpha: alpha,
+	}
+}
 
-	return pixels
+// Source of pixel rows for the renderers. read fills row with the pixels of
+// row y, it may be called for any row and from several goroutines at once
+type pixelRows struct {
+	width, height int
+	read          func(y int, row []Pixel)
 }
 
-func ColorDistance(mode RenderMode, c1 colorful.Color, c2 colorful.Color) float64 {
-	if mode == term16 { // heuristic; I think it looks nicer
-		return c1.DistanceLuv(c2)
-	} else {
-		return c1.DistanceLab(c2)
+func sliceRows(colors [][]Pixel) pixelRows {
+	rows := pixelRows{height: len(colors)}
+	if len(colors) > 0 {
+		rows.width = len(colors[0])
+	}
+	rows.read = func(y int, row []Pixel) {
+		copy(row, colors[y])
 	}
+	return rows
 }
 
-func IsTransparent(c color.Color) bool {
-	_, _, _, alpha := c.RGBA()
-	return alpha < TRANSPARENCY_THRESHOLD
+// Reads rows straight from img, without a full copy of the pixels
+func imageRows(img image.Image) pixelRows {
+	bounds := img.Bounds()
+	return pixelRows{
+		width:  bounds.Dx(),
+		height: bounds.Dy(),
+		read: func(y int, row []Pixel) {
+			for x := range row {
+				row[x] = MakePixel(img.At(bounds.Min.X+x, bounds.Min.Y+y))
+			}
+		},
+	}
 }
 
-func RenderBraille(colors [][]Pixel) string {
-	const braille_threshold = 0.5
-	// NOTE: the input image is always grayscale here
-	canvas := drawille.NewCanvas()
-
-	for y := 0; y < len(colors); y++ {
-		for x := 0; x < len(colors[0]); x++ {
-			oldpx := colors[y][x].color.R
-			quant_error := oldpx
-			if oldpx >= braille_threshold {
-				canvas.Set(x, y)
-				quant_error -= 1.0
-			}
-			if x+1 < len(colors[0]) {
-				colors[y][x+1].color.R = colors[y][x+1].color.R + quant_error*(7.0/16.0)
-			}
-			if y+1 < len(colors) {
-				if x > 0 {
-					colors[y+1][x-1].color.R = colors[y+1][x-1].color.R + quant_error*(3.0/16.0)
-				}
-				colors[y+1][x].color.R = colors[y+1][x].color.R + quant_error*(5.0/16.0)
-				if x+1 < len(colors[0]) {
-					colors[y+1][x+1].color.R = colors[y+1][x+1].color.R + quant_error*(1.0/16.0)
+// Rows of the averages of each pair of rows, for the layouts showing one color
+// in every cell
+func pairRows(rows pixelRows) pixelRows {
+	return pixelRows{
+		width:  rows.width,
+		height: (rows.height + 1) / 2,
+		read: func(y int, row []Pixel) {
+			rows.read(y*2, row)
+			if y*2+1 < rows.height {
+				below := make([]Pixel, len(row))
+				rows.read(y*2+1, below)
+				for x := range row {
+					row[x] = averagePixels(row[x], below[x])
 				}
 			}
-		}
+		},
 	}
-
-	return strings.Replace(canvas.String(), string('⠀'), string(' '), -1)
 }
 
-// Returns the palette index closest to the color in the current mode
-// converted to the proper string format
-func ColorString(mode RenderMode, px Pixel) string {
-	if px.alpha < TRANSPARENCY_THRESHOLD {
-		return "" // sentinel for transparent colors
-	}
-	if mode == term24bit {
-		r, g, b := px.color.RGB255()
-		return fmt.Sprintf("%d;%d;%d", r, g, b)
-	}
-	result := 0
-	last := len(colors[mode]) - 1
-	dist := ColorDistance(mode, px.color, colors[mode][last])
-	// start from the end so higher color indices are favored in the irc palette
-	for i := last - 1; i >= 0; i-- {
-		d := ColorDistance(mode, px.color, colors[mode][i])
-		if d < dist {
-			dist = d
-			result = i
-		}
+// Averages two pixels, a transparent one being left out
+func averagePixels(p, q Pixel) Pixel {
+	if q.alpha < TRANSPARENCY_THRESHOLD {
+		return p
 	}
-	return strconv.Itoa(result)
+	if p.alpha < TRANSPARENCY_THRESHOLD {
+		return q
+	}
+	p.color = colorful.Color{
+		R: (p.color.R + q.color.R) / 2,
+		G: (p.color.G + q.color.G) / 2,
+		B: (p.color.B + q.color.B) / 2,
+	}
+	if q.alpha > p.alpha {
+		p.alpha = q.alpha
+	}
+	return p
 }
 
-//
-// Escape squences
-//
+// Same as MetricAuto.Distance
+func ColorDistance(mode RenderMode, c1 colorful.Color, c2 colorful.Color) float64 {
+	return MetricAuto.Distance(mode, c1, c2)
+}
 
-func StartFGColor(mode RenderMode) string {
-	if mode == irc || mode == irc16 {
-		return "\x03"
-	} else {
-		// Foreground color selector is 38;
-		if mode == term24bit {
-			return "\x1B[38;2;"
-		} else {
-			return "\x1B[38;5;"
-		}
-	}
+func IsTransparent(c color.Color) bool {
+	_, _, _, alpha := c.RGBA()
+	return alpha < TRANSPARENCY_THRESHOLD
 }
 
-func StartBGColor(mode RenderMode) string {
-	if mode == irc || mode == irc16 {
-		return ","
-	} else {
-		// Background color selector is 48;
-		if mode == term24bit {
-			return "\x1B[48;2;"
-		} else { // 256
-			return "\x1B[48;5;"
-		}
-	}
+func RenderBraille(colors [][]Pixel) string {
+	var sb strings.Builder
+	renderBraille(&sb, sliceRows(colors), Options{})
+	return sb.String()
 }
 
-func EndColor(mode RenderMode) string {
-	if mode != irc && mode != irc16 {
-		// ANSI escape sequences are terminated by 'm'
-		return "m"
+// Returns the palette color closest to the pixel in the current mode
+func ResolveColor(mode RenderMode, px Pixel) Color {
+	if px.alpha < TRANSPARENCY_THRESHOLD {
+		return Color{}
 	}
-	return ""
+	return colorResolver(Options{Mode: mode})(px.color)
 }
 
-func Clear(mode RenderMode) string {
-	if mode != irc && mode != irc16 {
-		return "\x1B[0m"
+// Returns the palette of opts.Mode with the basic colors replaced by
+// opts.Palette, where they are used
+func (opts Options) palette() []colorful.Color {
+	palette := colors[opts.Mode]
+	if opts.Palette == nil || len(palette) == 0 {
+		return palette
 	}
-	return ""
+	palette = append([]colorful.Color(nil), palette...)
+	if opts.Mode == IRC || opts.Mode == IRC16 {
+		for i, ansi := range ircANSI {
+			palette[i] = opts.Palette[ansi]
+		}
+	} else {
+		copy(palette, opts.Palette)
+	}
+	return palette
 }
 
-func Render(mode RenderMode, use_spaces bool, colors [][]Pixel) string {
-	var buffer bytes.Buffer
-	ch := ""
-	step := 2
-	if use_spaces {
-		// Two spaces to keep aspect ratio
-		ch = "  "
-		step = 1
-	}
-
-	for y := 0; y < len(colors); y += step {
-		var prev_fg_col string
-		var prev_bg_col string
-		for x := 0; x < len(colors[0]); x++ {
-			next_fg_col := ColorString(mode, colors[y][x])
-			next_bg_col := ""
-			// Process two vertical pixels per column unless we're printing spaces
-			if !use_spaces {
-				ch = "▀"
-				if y+1 < len(colors) {
-					next_bg_col = ColorString(mode, colors[y+1][x])
-				}
-				if next_fg_col == "" {
-					if next_bg_col == "" {
-						ch = " "
-					} else {
-						ch = "▄"
-					}
-					next_fg_col = next_bg_col
-					next_bg_col = ""
-				}
-			} else {
-				next_bg_col = next_fg_col
-				next_fg_col = ""
-			}
+// The ANSI color closest in meaning to each of the 16 IRC ones, which clients
+// usually draw with the terminal palette
+var ircANSI = [16]int{15, 0, 4, 2, 9, 1, 5, 3, 11, 10, 6, 14, 12, 13, 8, 7}
 
-			if (next_bg_col == "" && prev_bg_col != "") ||
-				(next_fg_col == "" && prev_fg_col != "") {
-				buffer.WriteString(Clear(mode))
-				prev_bg_col = ""
-				prev_fg_col = ""
-			}
-
-			if next_fg_col == "" && next_bg_col == "" {
-				if prev_fg_col != "" || prev_bg_col != "" {
-					buffer.WriteString(Clear(mode))
-				}
-				prev_fg_col = ""
-				prev_bg_col = ""
-			} else if prev_fg_col != next_fg_col || prev_bg_col != next_bg_col {
-				if (mode == irc || mode == irc16) || prev_fg_col != next_fg_col {
-					if (mode == irc || mode == irc16) && next_fg_col == "" {
-						next_fg_col = "0"
-					}
-					buffer.WriteString(StartFGColor(mode))
-					buffer.WriteString(next_fg_col)
-					buffer.WriteString(EndColor(mode))
-					prev_fg_col = next_fg_col
-				}
-				if next_bg_col != "" && prev_bg_col != next_bg_col {
-					buffer.WriteString(StartBGColor(mode))
-					buffer.WriteString(next_bg_col)
-					buffer.WriteString(EndColor(mode))
-					prev_bg_col = next_bg_col
-				}
-			}
-			buffer.WriteString(ch)
+// Returns the function mapping colors to the palette of opts.Mode
+func colorResolver(opts Options) func(colorful.Color) Color {
+	if opts.Mode == Term24bit {
+		return func(c colorful.Color) Color {
+			return Color{Opaque: true, Index: -1, RGB: c}
 		}
-		buffer.WriteString(Clear(mode))
-		buffer.WriteString("\n")
 	}
-	return buffer.String()
+	return matcherFor(opts).resolver(opts.FastMatch)
+}
+
+// Returns the palette index closest to the color in the current mode
+// converted to the proper string format
+func ColorString(mode RenderMode, px Pixel) string {
+	return ResolveColor(mode, px).code()
 }
@@ -0,0 +1,106 @@
+package img2term
+
+import "github.com/lucasb-eyer/go-colorful"
+
+// Shade glyphs and how much of the cell they draw in the foreground color
+var shadeGlyphs = []struct {
+	glyph    string
+	coverage float64
+}{{"░", 0.25}, {"▒", 0.5}, {"▓", 0.75}}
+
+// A color of the mixed palette, glyph drawn in the palette color fg over bg
+type shade struct {
+	glyph    string
+	coverage float64
+	fg, bg   int
+}
+
+// Returns the mixed palette of n colors: the plain colors first, in order,
+// then every pair of them mixed by each of the shade glyphs. The reverse pairs
+// are left out, since ░ over one color looks like ▓ over the other
+func shadeMixes(n int) []shade {
+	shades := make([]shade, 0, n+n*(n-1)/2*len(shadeGlyphs))
+	for i := 0; i < n; i++ {
+		shades = append(shades, shade{glyph: "█", coverage: 1, fg: i, bg: -1})
+	}
+	for fg := 0; fg < n; fg++ {
+		for bg := fg + 1; bg < n; bg++ {
+			for _, s := range shadeGlyphs {
+				shades = append(shades, shade{glyph: s.glyph, coverage: s.coverage, fg: fg, bg: bg})
+			}
+		}
+	}
+	return shades
+}
+
+// Returns the shared matcher for the mixed palette of opts.Mode, along with
+// the shades its colors are drawn with. Colors are mixed in linear light, the
+// way the eye averages the pixels of the glyphs
+func shadeMatcher(opts Options) (*matcher, []shade) {
+	palette := opts.palette()
+	shades := shadeMixes(len(palette))
+	m := sharedMatcher(opts, "shades ", func() []colorful.Color {
+		mixed := make([]colorful.Color, len(shades))
+		for i, s := range shades {
+			if s.bg < 0 {
+				mixed[i] = palette[s.fg]
+				continue
+			}
+			fr, fg, fb := palette[s.fg].LinearRgb()
+			br, bg, bb := palette[s.bg].LinearRgb()
+			mixed[i] = colorful.LinearRgb(
+				br+(fr-br)*s.coverage,
+				bg+(fg-bg)*s.coverage,
+				bb+(fb-bb)*s.coverage,
+			)
+		}
+		return mixed
+	})
+	return m, shades
+}
+
+// Shade layout for the 16 color modes. Every cell shows a single color, the
+// average of the two pixels it covers, matched and dithered against the mixed
+// palette, so the basic colors go a lot further than with half blocks
+func layoutShades(rows pixelRows, opts Options, emit func([][]Cell) error) error {
+	m, shades := shadeMatcher(opts)
+	if opts.FastMatch && opts.CacheDir != "" {
+		m.cacheOnce.Do(func() { m.loadCache(opts.CacheDir) })
+		defer m.saveCache(opts.CacheDir)
+	}
+	plain := matcherFor(opts)
+	pairs := pairRows(rows)
+	jobs := opts.jobs()
+	band := jobs * bandRowsPerJob
+	colors := make([][]Color, band)
+	cells := make([][]Cell, band)
+	for i := range colors {
+		colors[i] = make([]Color, rows.width)
+		cells[i] = make([]Cell, rows.width)
+	}
+	resolver := newPaletteResolver(rows.width, opts, m.resolver(opts.FastMatch), m.spread)
+	for first := 0; first < pairs.height; first += band {
+		n := pairs.height - first
+		if n > band {
+			n = band
+		}
+		resolver.resolve(pairs, first, colors[:n], jobs)
+		parallelFor(n, jobs, func(i int) {
+			for x, c := range colors[i] {
+				cell := Cell{Glyph: " "}
+				if c.Opaque {
+					s := shades[c.Index]
+					cell.Glyph, cell.FG = s.glyph, plain.color(s.fg)
+					if s.bg >= 0 {
+						cell.BG = plain.color(s.bg)
+					}
+				}
+				cells[i][x] = cell
+			}
+		})
+		if err := emit(cells[:n]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package img2term
+
+import (
+	"bytes"
+	"image"
+	"io"
+	"math"
+	"strconv"
+)
+
+// Color registers available to sixel images
+const sixelRegisters = 256
+
+// Writes img as a DECSIXEL image using an adaptive palette
+func renderSixel(w io.Writer, img image.Image, opts Options) error {
+	rows := imageRows(img)
+	jobs := opts.jobs()
+	pixels := make([][]Pixel, rows.height)
+	parallelFor(rows.height, jobs, func(y int) {
+		pixels[y] = make([]Pixel, rows.width)
+		rows.read(y, pixels[y])
+	})
+
+	palette := medianCut(histogram(pixels), sixelRegisters)
+	m := newMatcher("", palette, opts.Metric.resolve(Sixel))
+	// The palette is made for this image alone, so a lookup table for it
+	// would cost more to fill than the exact search does
+	resolver := newPaletteResolver(rows.width, opts, m.nearest, m.spread)
+	resolved := make([][]Color, rows.height)
+	transparent := false
+	for y := range resolved {
+		resolved[y] = make([]Color, rows.width)
+		for _, px := range pixels[y] {
+			transparent = transparent || px.alpha < TRANSPARENCY_THRESHOLD
+		}
+	}
+	resolver.resolve(sliceRows(pixels), 0, resolved, jobs)
+
+	var header bytes.Buffer
+	// P2 = 1 leaves the pixels that aren't drawn transparent, instead of
+	// filling them with the background color
+	if transparent {
+		header.WriteString("\x1BP0;1;0q")
+	} else {
+		header.WriteString("\x1BP0;0;0q")
+	}
+	// 1:1 pixel aspect ratio and the image size
+	header.WriteString("\"1;1;" + strconv.Itoa(rows.width) + ";" + strconv.Itoa(rows.height))
+	for i, c := range palette {
+		header.WriteString("#" + strconv.Itoa(i) + ";2;" + sixelPercent(c.R) + ";" + sixelPercent(c.G) + ";" + sixelPercent(c.B))
+	}
+	if _, err := header.WriteTo(w); err != nil {
+		return err
+	}
+
+	// Every band of six rows is encoded separately
+	bands := make([]bytes.Buffer, (rows.height+5)/6)
+	parallelFor(len(bands), jobs, func(i int) {
+		end := i*6 + 6
+		if end > rows.height {
+			end = rows.height
+		}
+		sixelBand(&bands[i], resolved[i*6:end], rows.width, len(palette))
+		if i < len(bands)-1 {
+			bands[i].WriteByte('-')
+		}
+	})
+	for i := range bands {
+		if _, err := bands[i].WriteTo(w); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, "\x1B\\\n")
+	return err
+}
+
+// Color channel as a percentage, as sixel color registers take them
+func sixelPercent(v float64) string {
+	return strconv.Itoa(int(math.Round(math.Max(0, math.Min(1, v)) * 100)))
+}
+
+// Encodes up to six rows as one line of sixels per color used in them
+func sixelBand(buffer *bytes.Buffer, rows [][]Color, width, registers int) {
+	lines := make([][]byte, registers)
+	for bit, row := range rows {
+		for x, c := range row {
+			if !c.Opaque {
+				continue
+			}
+			if lines[c.Index] == nil {
+				lines[c.Index] = make([]byte, width)
+			}
+			lines[c.Index][x] |= 1 << uint(bit)
+		}
+	}
+	first := true
+	for index, line := range lines {
+		if line == nil {
+			continue
+		}
+		if !first {
+			buffer.WriteByte('$') // Back to the start of the band
+		}
+		first = false
+		buffer.WriteString("#" + strconv.Itoa(index))
+		// Trailing empty sixels don't need to be sent
+		end := len(line)
+		for end > 0 && line[end-1] == 0 {
+			end--
+		}
+		for x := 0; x < end; {
+			run := 1
+			for x+run < end && line[x+run] == line[x] {
+				run++
+			}
+			sixel := '?' + line[x]
+			if run > 3 {
+				buffer.WriteString("!" + strconv.Itoa(run))
+				buffer.WriteByte(sixel)
+			} else {
+				for i := 0; i < run; i++ {
+					buffer.WriteByte(sixel)
+				}
+			}
+			x += run
+		}
+	}
+}
@@ -0,0 +1,447 @@
+package img2term
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"math/bits"
+	"strings"
+	"sync"
+
+	"github.com/lucasb-eyer/go-colorful"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// Sets of characters GlyphsSymbols picks from. Only the ASCII masks are
+// rasterised from a font: the embedded 7x13 bitmap font has no glyphs for the
+// other sets, so their masks are drawn from the shapes the characters are
+// defined to have, which fonts follow closely for these ranges
+type Symbols uint
+
+const (
+	SymbolsASCII     Symbols = 1 << iota // Printable ASCII, from an embedded 7x13 bitmap font
+	SymbolsBlock                         // ▀▄▌▐█, the eighth blocks and the quadrants
+	SymbolsBox                           // Light, heavy and double box drawing lines
+	SymbolsGeometric                     // ■□●○◆▲◢ and the like
+	SymbolsBraille                       // Braille patterns
+
+	// Used when Options.Symbols is 0
+	SymbolsDefault = SymbolsBlock | SymbolsBox | SymbolsGeometric
+)
+
+var symbolsNames = []string{"ascii", "block", "box", "geometric", "braille"}
+
+// Returns the names of the sets in s, the way ParseSymbols takes them
+func (s Symbols) String() string {
+	var names []string
+	for i, name := range symbolsNames {
+		if s&(1<<uint(i)) != 0 {
+			names = append(names, name)
+		}
+	}
+	if rest := s >> uint(len(symbolsNames)); rest != 0 {
+		names = append(names, fmt.Sprintf("Symbols(%d)", uint(s)))
+	}
+	return strings.Join(names, ",")
+}
+
+// Returns the names of the sets accepted by ParseSymbols
+func SymbolsNames() []string {
+	return append([]string(nil), symbolsNames...)
+}
+
+// Parses a comma separated list of set names
+func ParseSymbols(list string) (Symbols, error) {
+	var s Symbols
+next:
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		for i, n := range symbolsNames {
+			if n == name {
+				s |= 1 << uint(i)
+				continue next
+			}
+		}
+		return 0, fmt.Errorf("unknown symbols %q", name)
+	}
+	return s, nil
+}
+
+// Pixels of the image every symbol cell covers. They are averaged in
+// vertical pairs into a square grid of samples, which the glyphs are
+// rasterised to
+const (
+	symbolWidth   = 8
+	symbolHeight  = 16
+	symbolSamples = symbolWidth * symbolHeight / 2
+	allSamples    = ^uint64(0)
+)
+
+// A glyph and the samples it covers, bit y*symbolWidth+x for the sample at x, y
+type symbol struct {
+	glyph string
+	mask  uint64
+	set   Symbols
+}
+
+var (
+	symbolTableOnce sync.Once
+	symbolTable     []symbol
+)
+
+// Returns the glyphs in the sets of s that cover different samples, the first
+// one being the space
+func symbolsFor(s Symbols) []symbol {
+	if s == 0 {
+		s = SymbolsDefault
+	}
+	symbolTableOnce.Do(func() { symbolTable = rasterSymbols() })
+	symbols := []symbol{{glyph: " "}}
+	seen := map[uint64]bool{0: true}
+	for _, sym := range symbolTable {
+		if sym.set&s != 0 && !seen[sym.mask] {
+			seen[sym.mask] = true
+			symbols = append(symbols, sym)
+		}
+	}
+	return symbols
+}
+
+// Rasterises the mask of every symbol, ASCII from basicfont and the others
+// from their shapes
+func rasterSymbols() []symbol {
+	var symbols []symbol
+	add := func(set Symbols, r rune, shape func(x, y int) bool) {
+		var mask uint64
+		for i := uint(0); i < symbolSamples; i++ {
+			if shape(int(i%symbolWidth), int(i/symbolWidth)) {
+				mask |= 1 << i
+			}
+		}
+		symbols = append(symbols, symbol{glyph: string(r), mask: mask, set: set})
+	}
+
+	for r := rune('!'); r <= '~'; r++ {
+		add(SymbolsASCII, r, fontShape(r))
+	}
+
+	add(SymbolsBlock, '▀', func(x, y int) bool { return y < 4 })
+	add(SymbolsBlock, '▔', func(x, y int) bool { return y < 1 })
+	add(SymbolsBlock, '▐', func(x, y int) bool { return x >= 4 })
+	add(SymbolsBlock, '▕', func(x, y int) bool { return x >= 7 })
+	for n := 1; n <= 8; n++ {
+		n := n
+		add(SymbolsBlock, 0x2580+rune(n), func(x, y int) bool { return y >= 8-n }) // ▁ to █
+	}
+	for n := 7; n >= 1; n-- {
+		n := n
+		add(SymbolsBlock, 0x2588+rune(8-n), func(x, y int) bool { return x < n }) // ▉ to ▏
+	}
+	for mask, glyph := range quadrantGlyphs {
+		mask := uint(mask)
+		add(SymbolsBlock, []rune(glyph)[0], func(x, y int) bool {
+			return mask&(1<<uint(x/4+y/4*2)) != 0
+		})
+	}
+
+	// Arms of the box drawing lines, in the order of boxGlyphs
+	arms := [][4]bool{ // up, right, down, left
+		{false, true, false, true}, {true, false, true, false},
+		{false, true, true, false}, {false, false, true, true}, {true, true, false, false}, {true, false, false, true},
+		{true, true, true, false}, {true, false, true, true}, {false, true, true, true}, {true, true, false, true},
+		{true, true, true, true},
+		{false, false, false, true}, {true, false, false, false}, {false, true, false, false}, {false, false, true, false},
+	}
+	for weight, glyphs := range boxGlyphs {
+		for i, r := range []rune(glyphs) {
+			add(SymbolsBox, r, boxShape(arms[i], weight))
+		}
+	}
+	add(SymbolsBox, '╱', func(x, y int) bool { return x+y == 7 })
+	add(SymbolsBox, '╲', func(x, y int) bool { return x == y })
+	add(SymbolsBox, '╳', func(x, y int) bool { return x+y == 7 || x == y })
+
+	for _, g := range geometricShapes {
+		shape := g.shape
+		add(SymbolsGeometric, g.glyph, func(x, y int) bool {
+			// Offsets in pixels from the center of the cell
+			return shape(float64(x)+0.5-symbolWidth/2, float64(y*2)+1-symbolHeight/2)
+		})
+	}
+
+	for b := rune(0); b < 0x100; b++ {
+		b := b
+		add(SymbolsBraille, 0x2800+b, func(x, y int) bool {
+			// Dots two pixels wide and tall, in the middle of their quarter
+			// of the width and the top of their eighth of the height
+			return b&brailleBits[y/2][x/4] != 0 && (x%4 == 1 || x%4 == 2) && y%2 == 0
+		})
+	}
+	return symbols
+}
+
+// Rasterises a character of the embedded font. Its pixels are narrower than
+// the samples are, so they are sampled across, and taller, so samples are set
+// when at least half of a pixel falls in them down
+func fontShape(r rune) func(x, y int) bool {
+	face := basicfont.Face7x13
+	dr, mask, maskp, advance, ok := face.Glyph(fixed.P(0, face.Ascent), r)
+	sw, sh := float64(advance.Round())/symbolWidth, float64(face.Height)*2/symbolHeight
+	return func(x, y int) bool {
+		px := int((float64(x) + 0.5) * sw)
+		covered := 0.0
+		for py := 0; ok && py < face.Height; py++ {
+			if !image.Pt(px, py).In(dr) {
+				continue
+			}
+			if _, _, _, a := mask.At(maskp.X+px-dr.Min.X, maskp.Y+py-dr.Min.Y).RGBA(); a >= 0x8000 {
+				covered += overlap(float64(py), float64(y)*sh, float64(y+1)*sh)
+			}
+		}
+		return covered >= 0.5
+	}
+}
+
+// Returns how much of the pixel starting at p falls between from and to
+func overlap(p, from, to float64) float64 {
+	return math.Max(0, math.Min(p+1, to)-math.Max(p, from))
+}
+
+// Box drawing glyphs by line weight (light, heavy and double), with the arms
+// listed in rasterSymbols
+var boxGlyphs = []string{"─│┌┐└┘├┤┬┴┼╴╵╶╷", "━┃┏┓┗┛┣┫┳┻╋╸╹╺╻", "═║╔╗╚╝╠╣╦╩╬"}
+
+// Lines run along the middle of the cell, light ones one sample wide, heavy
+// ones two and double ones as two light lines with a gap. Arms reach across
+// the lines they meet
+func boxShape(arms [4]bool, weight int) func(x, y int) bool {
+	onLine := func(v int) bool {
+		switch weight {
+		case 0:
+			return v == 3
+		case 1:
+			return v == 3 || v == 4
+		}
+		return v == 2 || v == 4
+	}
+	first, last := 3, 3
+	if weight > 0 {
+		last = 4
+	}
+	if weight == 2 {
+		first = 2
+	}
+	up, right, down, left := arms[0], arms[1], arms[2], arms[3]
+	return func(x, y int) bool {
+		return onLine(x) && (up && y <= last || down && y >= first) ||
+			onLine(y) && (left && x <= last || right && x >= first)
+	}
+}
+
+// Geometric shapes by their pixels, with x and y the offsets from the center
+// of a cell 8 pixels wide and 16 tall
+var geometricShapes = []struct {
+	glyph rune
+	shape func(x, y float64) bool
+}{
+	{'■', square(3.5)},
+	{'□', ring(square(3.5), square(2.5))},
+	{'▪', square(2)},
+	{'▬', func(x, y float64) bool { return math.Abs(x) < 3.5 && math.Abs(y) < 1.5 }},
+	{'▮', func(x, y float64) bool { return math.Abs(x) < 2 && math.Abs(y) < 5 }},
+	{'●', circle(3.5)},
+	{'○', ring(circle(3.5), circle(2.5))},
+	{'◐', halfCircle(func(x, y float64) bool { return x < 0 })},
+	{'◑', halfCircle(func(x, y float64) bool { return x > 0 })},
+	{'◒', halfCircle(func(x, y float64) bool { return y > 0 })},
+	{'◓', halfCircle(func(x, y float64) bool { return y < 0 })},
+	{'◆', diamond(4)},
+	{'◇', ring(diamond(4), diamond(2.5))},
+	{'▲', func(x, y float64) bool { return math.Abs(y) < 3.5 && math.Abs(x) < (y+3.5)/2 }},
+	{'▼', func(x, y float64) bool { return math.Abs(y) < 3.5 && math.Abs(x) < (3.5-y)/2 }},
+	{'▶', func(x, y float64) bool { return math.Abs(x) < 3.5 && math.Abs(y) < (3.5-x)/2 }},
+	{'◀', func(x, y float64) bool { return math.Abs(x) < 3.5 && math.Abs(y) < (x+3.5)/2 }},
+	// The corner triangles fill the whole cell
+	{'◢', func(x, y float64) bool { return x/symbolWidth+y/symbolHeight > 0 }},
+	{'◣', func(x, y float64) bool { return y/symbolHeight > x/symbolWidth }},
+	{'◤', func(x, y float64) bool { return x/symbolWidth+y/symbolHeight < 0 }},
+	{'◥', func(x, y float64) bool { return x/symbolWidth > y/symbolHeight }},
+	{'◧', func(x, y float64) bool { return square(3.5)(x, y) && (x < 0 || !square(2.5)(x, y)) }},
+	{'◨', func(x, y float64) bool { return square(3.5)(x, y) && (x > 0 || !square(2.5)(x, y)) }},
+}
+
+func square(r float64) func(x, y float64) bool {
+	return func(x, y float64) bool { return math.Abs(x) < r && math.Abs(y) < r }
+}
+
+func circle(r float64) func(x, y float64) bool {
+	return func(x, y float64) bool { return x*x+y*y < r*r }
+}
+
+func diamond(r float64) func(x, y float64) bool {
+	return func(x, y float64) bool { return math.Abs(x)+math.Abs(y) < r }
+}
+
+func ring(outer, inner func(x, y float64) bool) func(x, y float64) bool {
+	return func(x, y float64) bool { return outer(x, y) && !inner(x, y) }
+}
+
+// A circle outline with the half where filled is true filled in
+func halfCircle(filled func(x, y float64) bool) func(x, y float64) bool {
+	return func(x, y float64) bool {
+		return circle(3.5)(x, y) && (filled(x, y) || !circle(2.5)(x, y))
+	}
+}
+
+// Symbol layout, like chafa's. Every cell gets the glyph and pair of colors
+// that show its pixels with the least error, the colors being the averages of
+// the pixels on each side of the glyph. They are resolved to the palette after
+// fitting, so there is no dithering
+func layoutSymbols(rows pixelRows, opts Options, emit func([][]Cell) error) error {
+	symbols := symbolsFor(opts.Symbols)
+	jobs := opts.jobs()
+	resolve := colorResolver(opts)
+	band := jobs * bandRowsPerJob * symbolHeight
+	pixels := make([][]Pixel, band)
+	for i := range pixels {
+		pixels[i] = make([]Pixel, rows.width)
+	}
+	cells := make([][]Cell, band/symbolHeight)
+	for i := range cells {
+		cells[i] = make([]Cell, (rows.width+symbolWidth-1)/symbolWidth)
+	}
+	for first := 0; first < rows.height; first += band {
+		n := rows.height - first
+		if n > band {
+			n = band
+		}
+		parallelFor(n, jobs, func(i int) {
+			rows.read(first+i, pixels[i])
+		})
+		count := (n + symbolHeight - 1) / symbolHeight
+		parallelFor(count, jobs, func(i int) {
+			top, bottom := i*symbolHeight, (i+1)*symbolHeight
+			if bottom > n {
+				bottom = n
+			}
+			symbolCells(cells[i], pixels[top:bottom], symbols, resolve)
+		})
+		if err := emit(cells[:count]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Fills a row of cells from the rows of pixels they cover, fewer than
+// symbolHeight for the last row of cells of an image
+func symbolCells(cells []Cell, pixels [][]Pixel, symbols []symbol, resolve func(colorful.Color) Color) {
+	samples := make([]colorful.Color, symbolSamples)
+	points := make([]lab, symbolSamples)
+	for cx := range cells {
+		// Samples without any opaque pixels are transparent
+		var opaque uint64
+		for i := range samples {
+			x := cx*symbolWidth + i%symbolWidth
+			var sum colorful.Color
+			n := 0
+			for y := i / symbolWidth * 2; y < i/symbolWidth*2+2 && y < len(pixels); y++ {
+				if x < len(pixels[y]) && pixels[y][x].alpha >= TRANSPARENCY_THRESHOLD {
+					c := pixels[y][x].color
+					sum.R, sum.G, sum.B = sum.R+c.R, sum.G+c.G, sum.B+c.B
+					n++
+				}
+			}
+			if n > 0 {
+				opaque |= 1 << uint(i)
+				samples[i] = average(sum, n)
+				points[i] = toLab(samples[i])
+			}
+		}
+		cells[cx] = fitSymbol(samples, points, opaque, symbols, resolve)
+	}
+}
+
+func fitSymbol(samples []colorful.Color, points []lab, opaque uint64, symbols []symbol, resolve func(colorful.Color) Color) Cell {
+	if opaque == 0 {
+		return Cell{Glyph: " "}
+	}
+	best := symbols[0]
+	if opaque != allSamples {
+		// Transparent samples can only be left to the background, so the
+		// glyph is the one closest to covering just the opaque ones
+		bestMissed := symbolSamples + 1
+		for _, sym := range symbols {
+			if missed := bits.OnesCount64(sym.mask ^ opaque); missed < bestMissed {
+				best, bestMissed = sym, missed
+			}
+		}
+		cell := Cell{Glyph: best.glyph}
+		if best.mask&opaque != 0 {
+			cell.FG = resolve(meanColor(samples, best.mask&opaque))
+		}
+		return cell
+	}
+
+	// The error of a glyph is the squared distance of the samples to the mean
+	// of their side. It is the smallest when the squared sums of the sides,
+	// divided by their sizes, add up to the most
+	var total lab
+	for _, p := range points {
+		total.l, total.a, total.b = total.l+p.l, total.a+p.a, total.b+p.b
+	}
+	bestScore := math.Inf(-1)
+	for _, sym := range symbols {
+		n := bits.OnesCount64(sym.mask)
+		var score float64
+		if n == 0 || n == symbolSamples {
+			score = squaredNorm(total) / symbolSamples
+		} else {
+			var in lab
+			if n <= symbolSamples/2 {
+				in = sumPoints(points, sym.mask)
+			} else {
+				out := sumPoints(points, ^sym.mask)
+				in = lab{total.l - out.l, total.a - out.a, total.b - out.b}
+			}
+			out := lab{total.l - in.l, total.a - in.a, total.b - in.b}
+			score = squaredNorm(in)/float64(n) + squaredNorm(out)/float64(symbolSamples-n)
+		}
+		if score > bestScore {
+			best, bestScore = sym, score
+		}
+	}
+	cell := Cell{Glyph: best.glyph}
+	if best.mask != 0 {
+		cell.FG = resolve(meanColor(samples, best.mask))
+	}
+	if best.mask != allSamples {
+		cell.BG = resolve(meanColor(samples, ^best.mask))
+	}
+	return cell
+}
+
+func sumPoints(points []lab, mask uint64) lab {
+	var sum lab
+	for m := mask; m != 0; m &= m - 1 {
+		p := points[bits.TrailingZeros64(m)]
+		sum.l, sum.a, sum.b = sum.l+p.l, sum.a+p.a, sum.b+p.b
+	}
+	return sum
+}
+
+func squaredNorm(p lab) float64 {
+	return p.l*p.l + p.a*p.a + p.b*p.b
+}
+
+func meanColor(samples []colorful.Color, mask uint64) colorful.Color {
+	var sum colorful.Color
+	n := 0
+	for m := mask; m != 0; m &= m - 1 {
+		c := samples[bits.TrailingZeros64(m)]
+		sum.R, sum.G, sum.B = sum.R+c.R, sum.G+c.G, sum.B+c.B
+		n++
+	}
+	return average(sum, n)
+}
@@ -0,0 +1,269 @@
+package img2term
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The color capabilities of a compiled terminfo entry
+type Terminfo struct {
+	Names  []string // Terminal name and aliases, the last one is the description
+	Colors int      // Number of colors, -1 if not given
+	RGB    bool     // Direct colors, from the RGB or Tc extended capabilities
+	SGR0   string   // Turns off all attributes, without delays
+}
+
+var (
+	ErrNoTerminfo      = errors.New("no terminfo entry")
+	ErrCorruptTerminfo = errors.New("corrupt terminfo entry")
+)
+
+// Magic numbers of the legacy format, with 16-bit numbers, and of the
+// extended one, with 32-bit numbers
+const (
+	terminfoMagic   = 0432
+	terminfoMagic32 = 01036
+)
+
+// Indices of the capabilities in the standard order
+const (
+	terminfoColors = 13
+	terminfoSGR0   = 39
+)
+
+// Returns the directories searched for terminfo entries, like ncurses does
+func terminfoDirs(getenv func(string) string) []string {
+	if dir := getenv("TERMINFO"); dir != "" {
+		return []string{dir}
+	}
+	system := []string{"/etc/terminfo", "/lib/terminfo", "/usr/share/terminfo"}
+	var dirs []string
+	if home := getenv("HOME"); home != "" {
+		dirs = append(dirs, filepath.Join(home, ".terminfo"))
+	}
+	if list := getenv("TERMINFO_DIRS"); list != "" {
+		for _, dir := range strings.Split(list, ":") {
+			if dir == "" {
+				// An empty entry stands for the system directories
+				dirs = append(dirs, system...)
+			} else {
+				dirs = append(dirs, dir)
+			}
+		}
+		return dirs
+	}
+	return append(dirs, system...)
+}
+
+// Finds and parses the terminfo entry for the terminal called name
+func LoadTerminfo(name string) (*Terminfo, error) {
+	return loadTerminfo(name, os.Getenv)
+}
+
+func loadTerminfo(name string, getenv func(string) string) (*Terminfo, error) {
+	if name == "" || strings.ContainsAny(name, "/\\") || name[0] == '.' {
+		return nil, fmt.Errorf("%w: %q", ErrNoTerminfo, name)
+	}
+	for _, dir := range terminfoDirs(getenv) {
+		// Entries are kept under their first letter, or its hex code on
+		// case insensitive file systems
+		for _, sub := range []string{name[:1], fmt.Sprintf("%02x", name[0])} {
+			data, err := ioutil.ReadFile(filepath.Join(dir, sub, name))
+			if err != nil {
+				continue
+			}
+			info, err := ParseTerminfo(data)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", filepath.Join(dir, sub, name), err)
+			}
+			return info, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %q", ErrNoTerminfo, name)
+}
+
+// Reads little endian values out of a terminfo entry, remembering whether it
+// ran out of data
+type terminfoReader struct {
+	data []byte
+	pos  int
+	bad  bool
+}
+
+func (r *terminfoReader) bytes(n int) []byte {
+	if n < 0 || r.pos+n > len(r.data) {
+		r.bad = true
+		r.pos = len(r.data)
+		if n < 0 {
+			n = 0
+		}
+		return make([]byte, n)
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *terminfoReader) short() int {
+	return int(int16(binary.LittleEndian.Uint16(r.bytes(2))))
+}
+
+func (r *terminfoReader) number(wide bool) int {
+	if wide {
+		return int(int32(binary.LittleEndian.Uint32(r.bytes(4))))
+	}
+	return r.short()
+}
+
+// Skips the padding byte that keeps the following section aligned
+func (r *terminfoReader) align() {
+	if r.pos%2 == 1 && r.pos < len(r.data) {
+		r.pos++
+	}
+}
+
+// Returns the NUL terminated string at offset in table, or "" for negative
+// offsets (absent or cancelled capabilities)
+func tableString(table []byte, offset int) (string, bool) {
+	if offset < 0 {
+		return "", true
+	}
+	if offset >= len(table) {
+		return "", false
+	}
+	end := strings.IndexByte(string(table[offset:]), 0)
+	if end < 0 {
+		return "", false
+	}
+	return string(table[offset : offset+end]), true
+}
+
+// Parses a compiled terminfo entry in either the legacy or the extended
+// number format, including the extended capabilities section if present
+func ParseTerminfo(data []byte) (*Terminfo, error) {
+	r := &terminfoReader{data: data}
+	magic := r.short()
+	if magic != terminfoMagic && magic != terminfoMagic32 {
+		return nil, fmt.Errorf("%w: bad magic number %#o", ErrCorruptTerminfo, magic)
+	}
+	wide := magic == terminfoMagic32
+	namesSize, boolCount, numCount, strCount, tableSize := r.short(), r.short(), r.short(), r.short(), r.short()
+	if r.bad || namesSize < 0 || boolCount < 0 || numCount < 0 || strCount < 0 || tableSize < 0 {
+		return nil, fmt.Errorf("%w: bad header", ErrCorruptTerminfo)
+	}
+
+	names := strings.TrimRight(string(r.bytes(namesSize)), "\x00")
+	r.bytes(boolCount)
+	r.align()
+	numbers := make([]int, numCount)
+	for i := range numbers {
+		numbers[i] = r.number(wide)
+	}
+	offsets := make([]int, strCount)
+	for i := range offsets {
+		offsets[i] = r.short()
+	}
+	table := r.bytes(tableSize)
+	if r.bad {
+		return nil, fmt.Errorf("%w: truncated", ErrCorruptTerminfo)
+	}
+
+	info := &Terminfo{Names: strings.Split(names, "|"), Colors: -1}
+	if terminfoColors < len(numbers) && numbers[terminfoColors] >= 0 {
+		info.Colors = numbers[terminfoColors]
+	}
+	str := func(index int) (string, error) {
+		if index >= len(offsets) {
+			return "", nil
+		}
+		s, ok := tableString(table, offsets[index])
+		if !ok {
+			return "", fmt.Errorf("%w: bad string offset", ErrCorruptTerminfo)
+		}
+		return s, nil
+	}
+	sgr0, err := str(terminfoSGR0)
+	if err != nil {
+		return nil, err
+	}
+	info.SGR0 = stripDelays(sgr0)
+
+	r.align()
+	if r.pos >= len(data) {
+		return info, nil // No extended capabilities
+	}
+	extended, err := parseExtended(r, wide)
+	if err != nil {
+		return nil, err
+	}
+	info.RGB = extended["RGB"] || extended["Tc"]
+	return info, nil
+}
+
+// Returns the names of the extended boolean capabilities that are set
+func parseExtended(r *terminfoReader, wide bool) (map[string]bool, error) {
+	boolCount, numCount, strCount, _, tableSize := r.short(), r.short(), r.short(), r.short(), r.short()
+	if r.bad || boolCount < 0 || numCount < 0 || strCount < 0 || tableSize < 0 {
+		return nil, fmt.Errorf("%w: bad extended header", ErrCorruptTerminfo)
+	}
+	bools := r.bytes(boolCount)
+	r.align()
+	for i := 0; i < numCount; i++ {
+		r.number(wide)
+	}
+	valueOffsets := make([]int, strCount)
+	for i := range valueOffsets {
+		valueOffsets[i] = r.short()
+	}
+	nameOffsets := make([]int, boolCount+numCount+strCount)
+	for i := range nameOffsets {
+		nameOffsets[i] = r.short()
+	}
+	table := r.bytes(tableSize)
+	if r.bad {
+		return nil, fmt.Errorf("%w: truncated extended capabilities", ErrCorruptTerminfo)
+	}
+
+	// The names follow the string values in the table
+	namesStart := 0
+	for _, offset := range valueOffsets {
+		value, ok := tableString(table, offset)
+		if !ok {
+			return nil, fmt.Errorf("%w: bad extended string offset", ErrCorruptTerminfo)
+		}
+		if offset >= 0 && offset+len(value)+1 > namesStart {
+			namesStart = offset + len(value) + 1
+		}
+	}
+	set := map[string]bool{}
+	for i, value := range bools {
+		name, ok := tableString(table[namesStart:], nameOffsets[i])
+		if !ok {
+			return nil, fmt.Errorf("%w: bad extended name offset", ErrCorruptTerminfo)
+		}
+		if value == 1 {
+			set[name] = true
+		}
+	}
+	return set, nil
+}
+
+// Removes $<..> padding delays from a capability
+func stripDelays(capability string) string {
+	for {
+		start := strings.Index(capability, "$<")
+		if start < 0 {
+			return capability
+		}
+		end := strings.IndexByte(capability[start:], '>')
+		if end < 0 {
+			return capability
+		}
+		capability = capability[:start] + capability[start+end+1:]
+	}
+}
@@ -0,0 +1,247 @@
+package img2term
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Extended capabilities of a terminfo fixture
+type terminfoExtended struct {
+	bools   map[string]bool
+	numbers map[string]int
+	strings map[string]string
+}
+
+// Compiles a terminfo entry the way tic does, with 32-bit numbers if wide.
+// strs maps the indices of standard strings to their values
+func buildTerminfo(wide bool, names string, numbers []int, strs map[int]string, ext *terminfoExtended) []byte {
+	var b bytes.Buffer
+	short := func(v int) { binary.Write(&b, binary.LittleEndian, int16(v)) }
+	number := func(v int) {
+		if wide {
+			binary.Write(&b, binary.LittleEndian, int32(v))
+		} else {
+			short(v)
+		}
+	}
+	align := func() {
+		if b.Len()%2 == 1 {
+			b.WriteByte(0)
+		}
+	}
+	strCount := 0
+	for i := range strs {
+		if i+1 > strCount {
+			strCount = i + 1
+		}
+	}
+	var table bytes.Buffer
+	offsets := make([]int, strCount)
+	for i := range offsets {
+		offsets[i] = -1
+		if s, ok := strs[i]; ok {
+			offsets[i] = table.Len()
+			table.WriteString(s + "\x00")
+		}
+	}
+
+	magic := terminfoMagic
+	if wide {
+		magic = terminfoMagic32
+	}
+	short(magic)
+	short(len(names) + 1)
+	short(1) // A single boolean, unset
+	short(len(numbers))
+	short(strCount)
+	short(table.Len())
+	b.WriteString(names + "\x00")
+	b.WriteByte(0)
+	align()
+	for _, n := range numbers {
+		number(n)
+	}
+	for _, o := range offsets {
+		short(o)
+	}
+	b.Write(table.Bytes())
+	if ext == nil {
+		return b.Bytes()
+	}
+
+	align()
+	var values, extNames bytes.Buffer
+	var valueOffsets, nameOffsets []int
+	var boolValues []byte
+	var numberValues []int
+	for name, set := range ext.bools {
+		nameOffsets = append(nameOffsets, extNames.Len())
+		extNames.WriteString(name + "\x00")
+		if set {
+			boolValues = append(boolValues, 1)
+		} else {
+			boolValues = append(boolValues, 0)
+		}
+	}
+	for name, n := range ext.numbers {
+		nameOffsets = append(nameOffsets, extNames.Len())
+		extNames.WriteString(name + "\x00")
+		numberValues = append(numberValues, n)
+	}
+	for name, s := range ext.strings {
+		nameOffsets = append(nameOffsets, extNames.Len())
+		extNames.WriteString(name + "\x00")
+		valueOffsets = append(valueOffsets, values.Len())
+		values.WriteString(s + "\x00")
+	}
+	short(len(boolValues))
+	short(len(numberValues))
+	short(len(valueOffsets))
+	short(len(valueOffsets) + len(nameOffsets))
+	short(values.Len() + extNames.Len())
+	b.Write(boolValues)
+	align()
+	for _, n := range numberValues {
+		number(n)
+	}
+	for _, o := range valueOffsets {
+		short(o)
+	}
+	for _, o := range nameOffsets {
+		short(o)
+	}
+	b.Write(values.Bytes())
+	b.Write(extNames.Bytes())
+	return b.Bytes()
+}
+
+// Numbers with the given colors capability
+func colorNumbers(colors int) []int {
+	numbers := make([]int, terminfoColors+2)
+	for i := range numbers {
+		numbers[i] = -1
+	}
+	numbers[terminfoColors] = colors
+	return numbers
+}
+
+func TestParseTerminfo(t *testing.T) {
+	sgr0 := map[int]string{terminfoSGR0: "\x1B(B\x1B[m$<2>"}
+	for _, test := range []struct {
+		name   string
+		data   []byte
+		colors int
+		rgb    bool
+	}{
+		{"legacy", buildTerminfo(false, "xterm-256color|xterm with 256 colors", colorNumbers(256), sgr0, nil), 256, false},
+		{"no colors", buildTerminfo(false, "vt100|dec vt100", colorNumbers(-1), sgr0, nil), -1, false},
+		{"wide numbers", buildTerminfo(true, "xterm-direct|xterm with direct colors", colorNumbers(1<<24), sgr0, nil), 1 << 24, false},
+		{
+			"extended RGB",
+			buildTerminfo(false, "foot|foot terminal", colorNumbers(256), sgr0, &terminfoExtended{
+				bools:   map[string]bool{"RGB": true},
+				numbers: map[string]int{"U8": 1},
+				strings: map[string]string{"Ss": "\x1B[%p1%d q"},
+			}),
+			256, true,
+		},
+		{
+			"extended Tc",
+			buildTerminfo(true, "tmux-256color|tmux with 256 colors", colorNumbers(256), sgr0, &terminfoExtended{
+				bools:   map[string]bool{"Tc": true, "AX": true},
+				strings: map[string]string{"Smulx": "\x1B[4:%p1%dm"},
+			}),
+			256, true,
+		},
+		{
+			"extended unset",
+			buildTerminfo(false, "screen|GNU screen", colorNumbers(8), sgr0, &terminfoExtended{
+				bools: map[string]bool{"RGB": false},
+			}),
+			8, false,
+		},
+	} {
+		info, err := ParseTerminfo(test.data)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if info.Colors != test.colors || info.RGB != test.rgb {
+			t.Errorf("%s: got %d colors, RGB %v, want %d, %v", test.name, info.Colors, info.RGB, test.colors, test.rgb)
+		}
+		if info.SGR0 != "\x1B(B\x1B[m" {
+			t.Errorf("%s: sgr0 %q", test.name, info.SGR0)
+		}
+		if len(info.Names) != 2 {
+			t.Errorf("%s: names %q", test.name, info.Names)
+		}
+	}
+}
+
+func TestParseTerminfoCorrupt(t *testing.T) {
+	sgr0 := map[int]string{terminfoSGR0: "\x1B[m"}
+	base := buildTerminfo(false, "xterm|xterm", colorNumbers(8), sgr0, nil)
+	full := buildTerminfo(false, "xterm|xterm", colorNumbers(8), sgr0, &terminfoExtended{
+		bools: map[string]bool{"RGB": true},
+	})
+	for n := 0; n < len(full); n++ {
+		_, err := ParseTerminfo(full[:n])
+		if n == len(base) || n == len(base)+len(base)%2 {
+			// Cut right before the extended capabilities
+			if err != nil {
+				t.Errorf("cut at %d: %v", n, err)
+			}
+		} else if !errors.Is(err, ErrCorruptTerminfo) {
+			t.Errorf("cut at %d: got %v, want ErrCorruptTerminfo", n, err)
+		}
+	}
+
+	badMagic := append([]byte(nil), base...)
+	badMagic[0] = 0
+	badOffset := buildTerminfo(false, "xterm|xterm", colorNumbers(8), sgr0, nil)
+	// The offset of sgr0 points past the string table
+	binary.LittleEndian.PutUint16(badOffset[len(badOffset)-len("\x1B[m\x00")-2:], 100)
+	negative := append([]byte(nil), base...)
+	binary.LittleEndian.PutUint16(negative[4:], 0xffff) // Booleans
+	for name, data := range map[string][]byte{"bad magic": badMagic, "bad offset": badOffset, "negative count": negative} {
+		if _, err := ParseTerminfo(data); !errors.Is(err, ErrCorruptTerminfo) {
+			t.Errorf("%s: got %v, want ErrCorruptTerminfo", name, err)
+		}
+	}
+}
+
+func TestLoadTerminfo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "terminfo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// Under the hex code of the first letter, like on macOS
+	if err := os.Mkdir(filepath.Join(dir, "78"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	data := buildTerminfo(false, "xterm-256color|xterm", colorNumbers(256), nil, nil)
+	if err := ioutil.WriteFile(filepath.Join(dir, "78", "xterm-256color"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	getenv := func(name string) string {
+		if name == "TERMINFO" {
+			return dir
+		}
+		return ""
+	}
+	info, err := loadTerminfo("xterm-256color", getenv)
+	if err != nil || info.Colors != 256 {
+		t.Errorf("got %+v, %v", info, err)
+	}
+	for _, name := range []string{"xterm", "", "../xterm-256color", ".hidden"} {
+		if _, err := loadTerminfo(name, getenv); !errors.Is(err, ErrNoTerminfo) {
+			t.Errorf("%q: got %v, want ErrNoTerminfo", name, err)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package img2term
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/lucasb-eyer/go-colorful"
+)
+
+// Colors the terminal reported for its palette
+type TerminalPalette struct {
+	Colors     []colorful.Color // The 16 basic colors
+	Foreground *colorful.Color  // Default text color, nil if not reported
+	Background *colorful.Color
+}
+
+var ErrNoPalette = errors.New("terminal didn't report its palette")
+
+// Asks the terminal for its 16 basic colors with OSC 4, and its default
+// foreground and background with OSC 10 and 11. Terminals that don't answer
+// all 16 colors give ErrNoPalette
+func QueryPalette(env Environment) (*TerminalPalette, error) {
+	if env.Query == nil {
+		return nil, fmt.Errorf("%w: terminal can't be queried", ErrNoPalette)
+	}
+	var request strings.Builder
+	for i := 0; i < 16; i++ {
+		request.WriteString("\x1B]4;" + strconv.Itoa(i) + ";?\x1B\\")
+	}
+	request.WriteString("\x1B]10;?\x1B\\\x1B]11;?\x1B\\")
+	// Terminals answer in order, so the DA1 reply comes after the colors
+	request.WriteString(da1Query)
+	reply, err := env.Query(request.String(), func(reply string) bool {
+		_, ok := deviceAttributes(reply)
+		return ok
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNoPalette, err)
+	}
+
+	palette := &TerminalPalette{Colors: make([]colorful.Color, 16)}
+	found := 0
+	for _, answer := range oscReplies(reply) {
+		fields := strings.Split(answer, ";")
+		switch {
+		case len(fields) == 3 && fields[0] == "4":
+			index, err := strconv.Atoi(fields[1])
+			c, ok := parseXColor(fields[2])
+			if err == nil && ok && index >= 0 && index < 16 {
+				palette.Colors[index] = c
+				found++
+			}
+		case len(fields) == 2 && (fields[0] == "10" || fields[0] == "11"):
+			if c, ok := parseXColor(fields[1]); ok {
+				if fields[0] == "10" {
+					palette.Foreground = &c
+				} else {
+					palette.Background = &c
+				}
+			}
+		}
+	}
+	if found < 16 {
+		return nil, fmt.Errorf("%w: got %d of 16 colors", ErrNoPalette, found)
+	}
+	return palette, nil
+}
+
+// Returns the contents of the OSC replies in reply, which end with either BEL
+// or ST
+func oscReplies(reply string) []string {
+	var replies []string
+	for {
+		start := strings.Index(reply, "\x1B]")
+		if start < 0 {
+			return replies
+		}
+		reply = reply[start+2:]
+		end := strings.IndexAny(reply, "\a\x1B")
+		if end < 0 {
+			return replies
+		}
+		replies = append(replies, reply[:end])
+		reply = reply[end:]
+	}
+}
+
+// Parses colors in the rgb:r/g/b form terminals answer with, where each
+// channel has 1 to 4 hex digits
+func parseXColor(spec string) (colorful.Color, bool) {
+	if !strings.HasPrefix(spec, "rgb:") {
+		return colorful.Color{}, false
+	}
+	channels := strings.Split(spec[4:], "/")
+	if len(channels) != 3 {
+		return colorful.Color{}, false
+	}
+	var values [3]float64
+	for i, channel := range channels {
+		if len(channel) < 1 || len(channel) > 4 {
+			return colorful.Color{}, false
+		}
+		v, err := strconv.ParseUint(channel, 16, 16)
+		if err != nil {
+			return colorful.Color{}, false
+		}
+		values[i] = float64(v) / float64(uint64(1)<<(4*uint(len(channel)))-1)
+	}
+	return colorful.Color{R: values[0], G: values[1], B: values[2]}, true
+}
@@ -0,0 +1,120 @@
+package img2term
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestParseXColor(t *testing.T) {
+	for _, test := range []struct {
+		spec    string
+		r, g, b float64
+	}{
+		{"rgb:f/0/8", 1, 0, 8.0 / 15},
+		{"rgb:ff/80/00", 1, 128.0 / 255, 0},
+		{"rgb:fff/800/000", 1, 2048.0 / 4095, 0},
+		{"rgb:ffff/8000/0000", 1, 32768.0 / 65535, 0},
+		{"rgb:FFFF/cd/0", 1, 205.0 / 255, 0},
+	} {
+		c, ok := parseXColor(test.spec)
+		if !ok {
+			t.Errorf("%s: not parsed", test.spec)
+			continue
+		}
+		if math.Abs(c.R-test.r) > 1e-9 || math.Abs(c.G-test.g) > 1e-9 || math.Abs(c.B-test.b) > 1e-9 {
+			t.Errorf("%s: got %v, want %v %v %v", test.spec, c, test.r, test.g, test.b)
+		}
+	}
+	for _, spec := range []string{"", "rgb:", "rgb:fffff/0/0", "rgb:/0/0", "rgb:g/0/0", "rgb:1/2", "rgb:1/2/3/4", "#ffffff", "rgba:f/f/f/f"} {
+		if c, ok := parseXColor(spec); ok {
+			t.Errorf("%q parsed as %v", spec, c)
+		}
+	}
+}
+
+func TestOSCReplies(t *testing.T) {
+	for _, test := range []struct {
+		reply string
+		want  []string
+	}{
+		{"\x1B]4;1;rgb:cd/00/00\a", []string{"4;1;rgb:cd/00/00"}},
+		{"\x1B]4;1;rgb:cd/00/00\x1B\\", []string{"4;1;rgb:cd/00/00"}},
+		{"\x1B]10;rgb:e5/e5/e5\a\x1B]11;rgb:0/0/0\x1B\\\x1B[?62c", []string{"10;rgb:e5/e5/e5", "11;rgb:0/0/0"}},
+		{"garbage\x1B]4;0;rgb:0/0/0\a\x1B[?62c", []string{"4;0;rgb:0/0/0"}},
+		{"\x1B]4;0;rgb:0/0/0\a\x1B]4;1;rgb:cd", []string{"4;0;rgb:0/0/0"}}, // The last one is cut off
+		{"\x1B[?62;4c", nil},
+	} {
+		got := oscReplies(test.reply)
+		if fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("%q: got %q, want %q", test.reply, got, test.want)
+		}
+	}
+}
+
+// Returns the reply of a terminal with the xterm palette, each OSC reply
+// ending with BEL or ST in turns
+func xtermReply() string {
+	var reply strings.Builder
+	for i, hex := range builtinPalettes["xterm"] {
+		end := "\a"
+		if i%2 == 1 {
+			end = "\x1B\\"
+		}
+		fmt.Fprintf(&reply, "\x1B]4;%d;rgb:%s/%s/%s%s", i, hex[1:3], hex[3:5], hex[5:7], end)
+	}
+	reply.WriteString("\x1B]10;rgb:e5e5/e5e5/e5e5\x1B\\\x1B]11;rgb:0000/0000/0000\a\x1B[?62;22c")
+	return reply.String()
+}
+
+// Returns a query that gets reply in chunks of size bytes, like reads from
+// the terminal would, stopping once done accepts it
+func chunkedQuery(reply string, size int) func(string, func(string) bool) (string, error) {
+	return func(request string, done func(string) bool) (string, error) {
+		for n := 0; n < len(reply); {
+			n += size
+			if n > len(reply) {
+				n = len(reply)
+			}
+			if done(reply[:n]) {
+				return reply[:n], nil
+			}
+		}
+		return reply, errQueryTimeout
+	}
+}
+
+func TestQueryPalette(t *testing.T) {
+	reply := xtermReply()
+	for _, size := range []int{1, 2, 3, 7, 64, len(reply)} {
+		palette, err := QueryPalette(Environment{Query: chunkedQuery(reply, size)})
+		if err != nil {
+			t.Errorf("chunks of %d: %v", size, err)
+			continue
+		}
+		for i, c := range palette.Colors {
+			if want := builtinPalettes["xterm"][i]; c.Hex() != want {
+				t.Errorf("chunks of %d: color %d is %s, want %s", size, i, c.Hex(), want)
+			}
+		}
+		if palette.Foreground == nil || palette.Foreground.Hex() != "#e5e5e5" || palette.Background == nil || palette.Background.Hex() != "#000000" {
+			t.Errorf("chunks of %d: foreground %v, background %v", size, palette.Foreground, palette.Background)
+		}
+	}
+
+	// Terminals that only answer DA1, or answer part of the palette
+	partial := reply[strings.Index(reply, "\x1B]4;8;"):]
+	for name, query := range map[string]func(string, func(string) bool) (string, error){
+		"no colors": chunkedQuery("\x1B[?62;22c", 1),
+		"partial":   chunkedQuery(partial, 5),
+		"garbage":   chunkedQuery("\x1B]4;0;rgb:zz/00/00\a\x1B]4;x;rgb:0/0/0\a\x1B[?1;2c", 4),
+		"timeout":   chunkedQuery(reply[:100], 4),
+		"no query":  nil,
+	} {
+		if _, err := QueryPalette(Environment{Query: query}); !errors.Is(err, ErrNoPalette) {
+			t.Errorf("%s: got %v, want ErrNoPalette", name, err)
+		}
+	}
+}
@@ -0,0 +1,362 @@
+package img2term
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lucasb-eyer/go-colorful"
+)
+
+// Default colors of some terminals, in ANSI order
+var builtinPalettes = map[string][16]string{
+	"xterm": {
+		"#000000", "#cd0000", "#00cd00", "#cdcd00", "#0000ee", "#cd00cd", "#00cdcd", "#e5e5e5",
+		"#7f7f7f", "#ff0000", "#00ff00", "#ffff00", "#5c5cff", "#ff00ff", "#00ffff", "#ffffff",
+	},
+	// GNOME Terminal and other VTE based terminals (Tango)
+	"vte": {
+		"#2e3436", "#cc0000", "#4e9a06", "#c4a000", "#3465a4", "#75507b", "#06989a", "#d3d7cf",
+		"#555753", "#ef2929", "#8ae234", "#fce94f", "#729fcf", "#ad7fa8", "#34e2e2", "#eeeeec",
+	},
+	// Breeze
+	"konsole": {
+		"#232627", "#ed1515", "#11d116", "#f67400", "#1d99f3", "#9b59b6", "#1abc9c", "#fcfcfc",
+		"#7f8c8d", "#c0392b", "#1cdc9a", "#fdbc4b", "#3daee9", "#8e44ad", "#16a085", "#ffffff",
+	},
+	"rxvt": {
+		"#000000", "#cd0000", "#00cd00", "#cdcd00", "#0000cd", "#cd00cd", "#00cdcd", "#faebd7",
+		"#404040", "#ff0000", "#00ff00", "#ffff00", "#0000ff", "#ff00ff", "#00ffff", "#ffffff",
+	},
+	// The Linux console, same as VGA text mode
+	"linux": {
+		"#000000", "#aa0000", "#00aa00", "#aa5500", "#0000aa", "#aa00aa", "#00aaaa", "#aaaaaa",
+		"#555555", "#ff5555", "#55ff55", "#ffff55", "#5555ff", "#ff55ff", "#55ffff", "#ffffff",
+	},
+}
+
+// Returns the names of the built-in palettes accepted by LoadPalette
+func PaletteNames() []string {
+	var names []string
+	for name := range builtinPalettes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Returns the 16 colors of the built-in palette called name, or else of the
+// theme file at that path
+func LoadPalette(name string) ([]colorful.Color, error) {
+	if hexes, ok := builtinPalettes[name]; ok {
+		palette := make([]colorful.Color, 16)
+		for i, hex := range hexes {
+			palette[i], _ = parseHexColor(hex)
+		}
+		return palette, nil
+	}
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	palette, err := ParsePalette(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return palette, nil
+}
+
+// Parses the 16 colors, in ANSI order, out of a terminal theme. The format is
+// guessed from the contents, out of Xresources, base16 YAML, kitty.conf,
+// Alacritty TOML, Windows Terminal JSON, iTerm .itermcolors and a plain list
+// of hex colors
+func ParsePalette(data []byte) ([]colorful.Color, error) {
+	text := strings.TrimSpace(string(data))
+	var found map[int]colorful.Color
+	var err error
+	format := "hex list"
+	switch {
+	case strings.HasPrefix(text, "<"):
+		format = "iTerm"
+		found, err = parseITermColors(data)
+	case strings.HasPrefix(text, "{"):
+		format = "Windows Terminal"
+		found, err = parseWindowsTerminal(data)
+	case alacrittySection.MatchString(text):
+		format = "Alacritty"
+		found, err = parseAlacritty(text)
+	case base16Line.MatchString(text):
+		format = "base16"
+		found, err = parseBase16(text)
+	case xresourcesLine.MatchString(text):
+		format = "Xresources"
+		found, err = parseIndexed(expandDefines(text), xresourcesLine)
+	case kittyLine.MatchString(text):
+		format = "kitty"
+		found, err = parseIndexed(text, kittyLine)
+	default:
+		found, err = parseHexList(text)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s theme: %w", format, err)
+	}
+	palette := make([]colorful.Color, 16)
+	for i := range palette {
+		c, ok := found[i]
+		if !ok {
+			return nil, fmt.Errorf("%w, %s theme is missing color %d", ErrPalette, format, i)
+		}
+		palette[i] = c
+	}
+	return palette, nil
+}
+
+// Parses #rgb, #rrggbb, 0xrrggbb and rrggbb colors, and rgb:r/g/b like X does
+func parseHexColor(spec string) (colorful.Color, bool) {
+	if c, ok := parseXColor(spec); ok {
+		return c, true
+	}
+	switch {
+	case strings.HasPrefix(spec, "#"):
+		spec = spec[1:]
+	case strings.HasPrefix(spec, "0x"), strings.HasPrefix(spec, "0X"):
+		spec = spec[2:]
+	}
+	if len(spec) == 3 {
+		spec = string([]byte{spec[0], spec[0], spec[1], spec[1], spec[2], spec[2]})
+	}
+	if len(spec) != 6 {
+		return colorful.Color{}, false
+	}
+	v, err := strconv.ParseUint(spec, 16, 32)
+	if err != nil {
+		return colorful.Color{}, false
+	}
+	return colorful.Color{
+		R: float64(v>>16) / 255.0,
+		G: float64(v>>8&0xFF) / 255.0,
+		B: float64(v&0xFF) / 255.0,
+	}, true
+}
+
+func badColor(spec string) error {
+	return fmt.Errorf("bad color %q", spec)
+}
+
+// Lines of the line based formats. Xresources lines look like *color0: or
+// URxvt.color0:, kitty.conf ones like color0 #000000
+var (
+	xresourcesLine   = regexp.MustCompile(`(?m)^\s*[\w.*-]*[.*]color(\d+)\s*:\s*(\S+)`)
+	kittyLine        = regexp.MustCompile(`(?m)^\s*color(\d+)\s+(\S+)`)
+	base16Line       = regexp.MustCompile(`(?m)^\s*base0([0-9A-Fa-f])\s*:\s*["']?#?([0-9A-Fa-f]{6})`)
+	alacrittySection = regexp.MustCompile(`(?m)^\s*\[colors\.(normal|bright)\]`)
+	alacrittyLine    = regexp.MustCompile(`^\s*(\w+)\s*=\s*["']([^"']*)["']`)
+	hexToken         = regexp.MustCompile(`(?:#|\b0x|\b)[0-9A-Fa-f]{6}\b|#[0-9A-Fa-f]{3}\b`)
+	defineLine       = regexp.MustCompile(`(?m)^\s*#define\s+(\w+)\s+(\S+)`)
+	word             = regexp.MustCompile(`\b\w+\b`)
+)
+
+// Replaces the names given with #define in Xresources, which themes often use
+// for their colors
+func expandDefines(text string) string {
+	var replacements []string
+	for _, match := range defineLine.FindAllStringSubmatch(text, -1) {
+		replacements = append(replacements, match[1], match[2])
+	}
+	if replacements == nil {
+		return text
+	}
+	text = defineLine.ReplaceAllString(text, "")
+	return word.ReplaceAllStringFunc(text, func(name string) string {
+		for i := 0; i < len(replacements); i += 2 {
+			if replacements[i] == name {
+				return replacements[i+1]
+			}
+		}
+		return name
+	})
+}
+
+// Parses formats with a line per color, where re matches the index and the
+// color
+func parseIndexed(text string, re *regexp.Regexp) (map[int]colorful.Color, error) {
+	found := map[int]colorful.Color{}
+	for _, match := range re.FindAllStringSubmatch(text, -1) {
+		index, err := strconv.Atoi(match[1])
+		if err != nil || index >= 16 {
+			continue // Only the basic colors matter
+		}
+		c, ok := parseHexColor(match[2])
+		if !ok {
+			return nil, badColor(match[2])
+		}
+		found[index] = c
+	}
+	return found, nil
+}
+
+// Maps the base16 colors to the ANSI ones like base16-shell does
+var base16ANSI = [16]int{0x0, 0x8, 0xB, 0xA, 0xD, 0xE, 0xC, 0x5, 0x3, 0x8, 0xB, 0xA, 0xD, 0xE, 0xC, 0x7}
+
+func parseBase16(text string) (map[int]colorful.Color, error) {
+	var base [16]*colorful.Color
+	for _, match := range base16Line.FindAllStringSubmatch(text, -1) {
+		index, _ := strconv.ParseUint(match[1], 16, 8)
+		c, _ := parseHexColor(match[2])
+		base[index] = &c
+	}
+	found := map[int]colorful.Color{}
+	for i, b := range base16ANSI {
+		if base[b] != nil {
+			found[i] = *base[b]
+		}
+	}
+	return found, nil
+}
+
+// Alacritty names the colors in its [colors.normal] and [colors.bright] tables
+var ansiNames = []string{"black", "red", "green", "yellow", "blue", "magenta", "cyan", "white"}
+
+func parseAlacritty(text string) (map[int]colorful.Color, error) {
+	found := map[int]colorful.Color{}
+	offset := -1
+	for _, line := range strings.Split(text, "\n") {
+		if match := alacrittySection.FindStringSubmatch(line); match != nil {
+			offset = 0
+			if match[1] == "bright" {
+				offset = 8
+			}
+			continue
+		}
+		if strings.HasPrefix(strings.TrimSpace(line), "[") {
+			offset = -1
+			continue
+		}
+		match := alacrittyLine.FindStringSubmatch(line)
+		if offset < 0 || match == nil {
+			continue
+		}
+		for i, name := range ansiNames {
+			if match[1] == name {
+				c, ok := parseHexColor(match[2])
+				if !ok {
+					return nil, badColor(match[2])
+				}
+				found[offset+i] = c
+			}
+		}
+	}
+	return found, nil
+}
+
+// Windows Terminal calls magenta purple, and prefixes the bright colors
+var windowsTerminalNames = []string{
+	"black", "red", "green", "yellow", "blue", "purple", "cyan", "white",
+	"brightBlack", "brightRed", "brightGreen", "brightYellow", "brightBlue", "brightPurple", "brightCyan", "brightWhite",
+}
+
+// Parses a Windows Terminal color scheme, or the first scheme of a whole
+// settings file
+func parseWindowsTerminal(data []byte) (map[int]colorful.Color, error) {
+	var scheme map[string]json.RawMessage
+	if err := json.Unmarshal(data, &scheme); err != nil {
+		return nil, err
+	}
+	if raw, ok := scheme["schemes"]; ok {
+		var schemes []map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &schemes); err != nil {
+			return nil, err
+		}
+		if len(schemes) == 0 {
+			return nil, fmt.Errorf("no color schemes")
+		}
+		scheme = schemes[0]
+	}
+	found := map[int]colorful.Color{}
+	for i, name := range windowsTerminalNames {
+		var spec string
+		if raw, ok := scheme[name]; !ok || json.Unmarshal(raw, &spec) != nil {
+			continue
+		}
+		c, ok := parseHexColor(spec)
+		if !ok {
+			return nil, badColor(spec)
+		}
+		found[i] = c
+	}
+	return found, nil
+}
+
+// Any element of a property list
+type plistNode struct {
+	XMLName xml.Name
+	Text    string      `xml:",chardata"`
+	Nodes   []plistNode `xml:",any"`
+}
+
+// Returns the values of a plist dict by key
+func (n plistNode) dict() map[string]plistNode {
+	values := map[string]plistNode{}
+	for i := 0; i+1 < len(n.Nodes); i += 2 {
+		if n.Nodes[i].XMLName.Local == "key" {
+			values[strings.TrimSpace(n.Nodes[i].Text)] = n.Nodes[i+1]
+		}
+	}
+	return values
+}
+
+// Parses an iTerm .itermcolors property list, where the "Ansi N Color" dicts
+// have the channels as reals from 0 to 1
+func parseITermColors(data []byte) (map[int]colorful.Color, error) {
+	var root plistNode
+	if err := xml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	if len(root.Nodes) == 0 || root.Nodes[0].XMLName.Local != "dict" {
+		return nil, fmt.Errorf("no dict in the property list")
+	}
+	found := map[int]colorful.Color{}
+	for key, value := range root.Nodes[0].dict() {
+		var index int
+		if _, err := fmt.Sscanf(key, "Ansi %d Color", &index); err != nil || index < 0 || index >= 16 {
+			continue
+		}
+		var channels [3]float64
+		components := value.dict()
+		for i, name := range []string{"Red", "Green", "Blue"} {
+			component, ok := components[name+" Component"]
+			if !ok {
+				return nil, fmt.Errorf("%s has no %s component", key, name)
+			}
+			v, err := strconv.ParseFloat(strings.TrimSpace(component.Text), 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", key, err)
+			}
+			channels[i] = v
+		}
+		found[index] = colorful.Color{R: channels[0], G: channels[1], B: channels[2]}
+	}
+	return found, nil
+}
+
+// Parses a list of 16 hex colors separated by anything
+func parseHexList(text string) (map[int]colorful.Color, error) {
+	tokens := hexToken.FindAllString(text, -1)
+	if len(tokens) > 16 {
+		return nil, fmt.Errorf("%d colors, not 16", len(tokens))
+	}
+	found := map[int]colorful.Color{}
+	for i, token := range tokens {
+		c, ok := parseHexColor(token)
+		if !ok {
+			return nil, badColor(token)
+		}
+		found[i] = c
+	}
+	return found, nil
+}
@@ -0,0 +1,74 @@
+package img2term
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadPaletteFormats(t *testing.T) {
+	xterm := builtinPalettes["xterm"]
+	// base16 slots in ANSI order like base16-shell: 00, 08, 0B, 0A, 0D, 0E,
+	// 0C, 05, 03, 08, 0B, 0A, 0D, 0E, 0C, 07, every slot N being #NNNNNN
+	grays := [16]string{
+		"#000000", "#888888", "#bbbbbb", "#aaaaaa", "#dddddd", "#eeeeee", "#cccccc", "#555555",
+		"#333333", "#888888", "#bbbbbb", "#aaaaaa", "#dddddd", "#eeeeee", "#cccccc", "#777777",
+	}
+	for _, test := range []struct {
+		file string
+		want [16]string
+	}{
+		{"xterm.Xresources", xterm},
+		{"grays.yaml", grays},
+		{"xterm-alacritty.toml", xterm},
+		{"xterm-windows-terminal.json", xterm},
+		{"xterm.itermcolors", xterm},
+		{"xterm-kitty.conf", xterm},
+		{"xterm.txt", xterm},
+	} {
+		palette, err := LoadPalette(filepath.Join("testdata", "themes", test.file))
+		if err != nil {
+			t.Errorf("%s: %v", test.file, err)
+			continue
+		}
+		for i, c := range palette {
+			if c.Hex() != test.want[i] {
+				t.Errorf("%s: color %d is %s, want %s", test.file, i, c.Hex(), test.want[i])
+			}
+		}
+	}
+}
+
+func TestParsePaletteErrors(t *testing.T) {
+	for _, text := range []string{
+		"*color0: #000000\n*color1: #cd0000\n",
+		"color0 #000000\ncolor1 nothex\n",
+		"#000000 #111111 #222222",
+		`{"schemes": []}`,
+		`{"black": "#000000"`,
+		"<plist><dict><key>Ansi 0 Color</key><dict></dict></dict></plist>",
+	} {
+		if _, err := ParsePalette([]byte(text)); err == nil {
+			t.Errorf("%q was accepted", text)
+		}
+	}
+	_, err := ParsePalette([]byte("#000000 #111111 #222222"))
+	if !errors.Is(err, ErrPalette) {
+		t.Errorf("missing colors: got %v, want ErrPalette", err)
+	}
+}
+
+func TestBuiltinPalettes(t *testing.T) {
+	for _, name := range PaletteNames() {
+		palette, err := LoadPalette(name)
+		if err != nil || len(palette) != 16 {
+			t.Errorf("%s: %d colors, %v", name, len(palette), err)
+			continue
+		}
+		for i, c := range palette {
+			if c.Hex() != builtinPalettes[name][i] {
+				t.Errorf("%s: color %d is %s, want %s", name, i, c.Hex(), builtinPalettes[name][i])
+			}
+		}
+	}
+}
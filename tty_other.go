@@ -0,0 +1,13 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package img2term
+
+import (
+	"errors"
+	"time"
+)
+
+func queryTerminal(request string, done func(reply string) bool, timeout time.Duration) (string, error) {
+	return "", errors.New("querying the terminal isn't supported on this system")
+}
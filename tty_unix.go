@@ -0,0 +1,57 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package img2term
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/ssh/terminal"
+	"golang.org/x/sys/unix"
+)
+
+// Sends request to the controlling terminal in raw mode and reads the reply
+// until done accepts it or timeout passes
+func queryTerminal(request string, done func(reply string) bool, timeout time.Duration) (string, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return "", err
+	}
+	defer tty.Close()
+	fd := int(tty.Fd())
+	state, err := terminal.MakeRaw(fd)
+	if err != nil {
+		return "", err
+	}
+	defer terminal.Restore(fd, state)
+
+	if _, err := tty.WriteString(request); err != nil {
+		return "", err
+	}
+	var reply []byte
+	buffer := make([]byte, 256)
+	deadline := time.Now().Add(timeout)
+	for !done(string(reply)) {
+		left := time.Until(deadline)
+		if left <= 0 {
+			return string(reply), errQueryTimeout
+		}
+		fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+		n, err := unix.Poll(fds, int(left/time.Millisecond)+1)
+		if errors.Is(err, unix.EINTR) {
+			continue
+		} else if err != nil {
+			return string(reply), err
+		} else if n == 0 {
+			continue
+		}
+		n, err = tty.Read(buffer)
+		if err != nil {
+			return string(reply), err
+		}
+		reply = append(reply, buffer[:n]...)
+	}
+	return string(reply), nil
+}